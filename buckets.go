@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"gopkg.in/yaml.v3"
+)
+
+const bucketsFile = "buckets.yaml"
+
+// defaultNotifyTarget is the ARN of the NATS notification target the
+// platform compose file configures in the local MinIO.
+const defaultNotifyTarget = "arn:minio:sqs::POLYCODE:nats"
+
+const defaultFilesEventsSubject = "polycode.files.events"
+
+// BucketSpec declares a bucket of the local MinIO in ~/.polycode/buckets.yaml.
+// The bucket is brought in line with the spec on every platform start, so
+// removing a setting also removes it from the bucket.
+type BucketSpec struct {
+	Name          string             `yaml:"name"`
+	Versioning    bool               `yaml:"versioning"`
+	Lifecycle     []LifecycleSpec    `yaml:"lifecycle"`
+	CORS          []CORSSpec         `yaml:"cors"`
+	Notifications []NotificationSpec `yaml:"notifications"`
+}
+
+// LifecycleSpec expires objects under a prefix after a number of days.
+type LifecycleSpec struct {
+	ID         string `yaml:"id"`
+	Prefix     string `yaml:"prefix"`
+	ExpireDays int32  `yaml:"expireDays"`
+}
+
+type CORSSpec struct {
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	AllowedMethods []string `yaml:"allowedMethods"`
+	AllowedHeaders []string `yaml:"allowedHeaders"`
+	ExposeHeaders  []string `yaml:"exposeHeaders"`
+	MaxAgeSeconds  int32    `yaml:"maxAgeSeconds"`
+}
+
+// NotificationSpec sends bucket events to a MinIO notification target,
+// NATS by default.
+type NotificationSpec struct {
+	ID     string   `yaml:"id"`
+	Events []string `yaml:"events"`
+	Prefix string   `yaml:"prefix"`
+	Suffix string   `yaml:"suffix"`
+	Target string   `yaml:"target"`
+}
+
+func loadBucketSpecs() ([]BucketSpec, error) {
+	path := filepath.Join(getPolycodeDir(), bucketsFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var file struct {
+		Buckets []BucketSpec `yaml:"buckets"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	hasFiles := false
+	for _, b := range file.Buckets {
+		if b.Name == "" {
+			return nil, fmt.Errorf("%s: every bucket needs a name", path)
+		}
+		hasFiles = hasFiles || b.Name == filesBucket
+	}
+	// The files bucket is always provisioned, `polycode files` relies on it
+	if !hasFiles {
+		file.Buckets = append(file.Buckets, BucketSpec{Name: filesBucket})
+	}
+	return file.Buckets, nil
+}
+
+func apiErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+// provisionBuckets creates every declared bucket and applies its settings.
+func provisionBuckets(ctx context.Context, s3client *s3.Client, specs []BucketSpec) error {
+	for _, spec := range specs {
+		if err := ensureBucket(ctx, s3client, spec.Name); err != nil {
+			return err
+		}
+		if err := applyVersioning(ctx, s3client, spec); err != nil {
+			return err
+		}
+		if err := applyLifecycle(ctx, s3client, spec); err != nil {
+			return err
+		}
+		if err := applyCORS(ctx, s3client, spec); err != nil {
+			return err
+		}
+		if err := applyNotifications(ctx, s3client, spec); err != nil {
+			return err
+		}
+		fmt.Println("✅ Bucket", spec.Name)
+	}
+	return nil
+}
+
+func applyVersioning(ctx context.Context, s3client *s3.Client, spec BucketSpec) error {
+	status := types.BucketVersioningStatusEnabled
+	if !spec.Versioning {
+		// Versioning can only be suspended once enabled
+		current, err := s3client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+			Bucket: aws.String(spec.Name),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get versioning of %s: %w", spec.Name, err)
+		}
+		if current.Status != types.BucketVersioningStatusEnabled {
+			return nil
+		}
+		status = types.BucketVersioningStatusSuspended
+	}
+
+	_, err := s3client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(spec.Name),
+		VersioningConfiguration: &types.VersioningConfiguration{Status: status},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set versioning of %s: %w", spec.Name, err)
+	}
+	return nil
+}
+
+func applyLifecycle(ctx context.Context, s3client *s3.Client, spec BucketSpec) error {
+	if len(spec.Lifecycle) == 0 {
+		_, err := s3client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{
+			Bucket: aws.String(spec.Name),
+		})
+		if err != nil && apiErrorCode(err) != "NoSuchLifecycleConfiguration" {
+			return fmt.Errorf("failed to clear lifecycle of %s: %w", spec.Name, err)
+		}
+		return nil
+	}
+
+	rules := make([]types.LifecycleRule, 0, len(spec.Lifecycle))
+	for i, l := range spec.Lifecycle {
+		if l.ExpireDays <= 0 {
+			return fmt.Errorf("bucket %s: lifecycle rule %d needs expireDays", spec.Name, i+1)
+		}
+		rules = append(rules, types.LifecycleRule{
+			ID:         aws.String(firstNonEmpty(l.ID, fmt.Sprintf("expire-%d", i+1))),
+			Status:     types.ExpirationStatusEnabled,
+			Filter:     &types.LifecycleRuleFilter{Prefix: aws.String(l.Prefix)},
+			Expiration: &types.LifecycleExpiration{Days: aws.Int32(l.ExpireDays)},
+		})
+	}
+
+	_, err := s3client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(spec.Name),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: rules},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set lifecycle of %s: %w", spec.Name, err)
+	}
+	return nil
+}
+
+// applyCORS sets per-bucket CORS rules. MinIO releases without bucket CORS
+// support answer NotImplemented; those only honour the platform-wide
+// platform.corsOrigins setting.
+func applyCORS(ctx context.Context, s3client *s3.Client, spec BucketSpec) error {
+	var err error
+	if len(spec.CORS) == 0 {
+		_, err = s3client.DeleteBucketCors(ctx, &s3.DeleteBucketCorsInput{
+			Bucket: aws.String(spec.Name),
+		})
+	} else {
+		rules := make([]types.CORSRule, 0, len(spec.CORS))
+		for _, c := range spec.CORS {
+			rule := types.CORSRule{
+				AllowedOrigins: c.AllowedOrigins,
+				AllowedMethods: c.AllowedMethods,
+				AllowedHeaders: c.AllowedHeaders,
+				ExposeHeaders:  c.ExposeHeaders,
+			}
+			if c.MaxAgeSeconds > 0 {
+				rule.MaxAgeSeconds = aws.Int32(c.MaxAgeSeconds)
+			}
+			rules = append(rules, rule)
+		}
+		_, err = s3client.PutBucketCors(ctx, &s3.PutBucketCorsInput{
+			Bucket:            aws.String(spec.Name),
+			CORSConfiguration: &types.CORSConfiguration{CORSRules: rules},
+		})
+	}
+
+	switch apiErrorCode(err) {
+	case "":
+		if err != nil {
+			return fmt.Errorf("failed to set CORS of %s: %w", spec.Name, err)
+		}
+	case "NotImplemented":
+		if len(spec.CORS) > 0 {
+			fmt.Printf("⚠️  MinIO does not support bucket CORS, %s uses the platform.corsOrigins setting.\n", spec.Name)
+		}
+	case "NoSuchCORSConfiguration":
+	default:
+		return fmt.Errorf("failed to set CORS of %s: %w", spec.Name, err)
+	}
+	return nil
+}
+
+func applyNotifications(ctx context.Context, s3client *s3.Client, spec BucketSpec) error {
+	queues := make([]types.QueueConfiguration, 0, len(spec.Notifications))
+	for i, n := range spec.Notifications {
+		if len(n.Events) == 0 {
+			return fmt.Errorf("bucket %s: notification %d needs events", spec.Name, i+1)
+		}
+
+		q := types.QueueConfiguration{
+			Id:       aws.String(firstNonEmpty(n.ID, fmt.Sprintf("notify-%d", i+1))),
+			QueueArn: aws.String(firstNonEmpty(n.Target, defaultNotifyTarget)),
+		}
+		for _, e := range n.Events {
+			q.Events = append(q.Events, types.Event(e))
+		}
+
+		var rules []types.FilterRule
+		if n.Prefix != "" {
+			rules = append(rules, types.FilterRule{Name: types.FilterRuleNamePrefix, Value: aws.String(n.Prefix)})
+		}
+		if n.Suffix != "" {
+			rules = append(rules, types.FilterRule{Name: types.FilterRuleNameSuffix, Value: aws.String(n.Suffix)})
+		}
+		if len(rules) > 0 {
+			q.Filter = &types.NotificationConfigurationFilter{Key: &types.S3KeyFilter{FilterRules: rules}}
+		}
+		queues = append(queues, q)
+	}
+
+	_, err := s3client.PutBucketNotificationConfiguration(ctx, &s3.PutBucketNotificationConfigurationInput{
+		Bucket:                    aws.String(spec.Name),
+		NotificationConfiguration: &types.NotificationConfiguration{QueueConfigurations: queues},
+	})
+	if err != nil {
+		if apiErrorCode(err) == "InvalidArgument" {
+			return fmt.Errorf("failed to set notifications of %s, check that the target is configured in MinIO: %w", spec.Name, err)
+		}
+		return fmt.Errorf("failed to set notifications of %s: %w", spec.Name, err)
+	}
+	return nil
+}
+
+// filesNotifications returns the object created and removed notifications
+// for the files bucket, one per configured prefix.
+func filesNotifications(cfg *Config) []NotificationSpec {
+	events := []string{"s3:ObjectCreated:*", "s3:ObjectRemoved:*"}
+
+	var prefixes []string
+	for _, p := range strings.Split(cfg.Files.EventPrefixes, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+	if len(prefixes) == 0 {
+		return []NotificationSpec{{ID: "polycode-files-events", Events: events}}
+	}
+
+	specs := make([]NotificationSpec, 0, len(prefixes))
+	for i, p := range prefixes {
+		specs = append(specs, NotificationSpec{
+			ID:     fmt.Sprintf("polycode-files-events-%d", i+1),
+			Events: events,
+			Prefix: p,
+		})
+	}
+	return specs
+}
+
+// registerFilesEvents publishes object events of the files bucket to NATS,
+// unless buckets.yaml already declares notifications for it. Failures only
+// warn: MinIO started from an older compose file has no NATS target.
+func registerFilesEvents(ctx context.Context, s3client *s3.Client, cfg *Config, specs []BucketSpec) {
+	var spec BucketSpec
+	for _, b := range specs {
+		if b.Name == filesBucket {
+			spec = b
+		}
+	}
+	if len(spec.Notifications) > 0 {
+		return
+	}
+
+	spec.Notifications = filesNotifications(cfg)
+	if err := applyNotifications(ctx, s3client, spec); err != nil {
+		fmt.Printf("⚠️  File events are not published to NATS: %v\n", err)
+		fmt.Println("   If the platform was started by an older CLI, restart it with `polycode platform stop && polycode platform start`.")
+		return
+	}
+	fmt.Printf("📨 %s events -> NATS %s\n", filesBucket, filesEventsSubject(cfg))
+}
+
+func filesEventsSubject(cfg *Config) string {
+	return firstNonEmpty(cfg.Files.EventsSubject, defaultFilesEventsSubject)
+}
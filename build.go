@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type BuildOptions struct {
+	// Force rebuilds the image even when nothing it depends on has changed.
+	Force bool
+	// ExportCache exports the BuildKit cache to ~/.polycode/cache/<image>.
+	// This needs a buildx builder that supports cache export (e.g. docker-container).
+	ExportCache bool
+}
+
+func getCacheDir() string {
+	cacheDir := filepath.Join(getPolycodeDir(), "cache")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		panic(fmt.Errorf("failed to create cache directory: %w", err))
+	}
+	return cacheDir
+}
+
+// cacheName turns an image tag into something safe to use as a file name.
+func cacheName(imageTag string) string {
+	return strings.NewReplacer("/", "_", ":", "_").Replace(imageTag)
+}
+
+// remoteImageDigest resolves the registry digest of image without pulling it.
+func remoteImageDigest(image string) (string, error) {
+	digest, err := containerEngine().ManifestDigest(image)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve digest of %s: %w", image, err)
+	}
+	return digest, nil
+}
+
+func imageExists(imageTag string) bool {
+	if api := engineAPI(); api != nil {
+		_, err := api.ImageInspect(context.Background(), imageTag)
+		return err == nil
+	}
+	return runCmd(containerEngine().Command("image", "inspect", imageTag)) == nil
+}
+
+// buildFingerprint hashes everything the dev image is built from, apart from
+// the project sources which are mounted at runtime. It also returns the
+// resolved base-image digest.
+func buildFingerprint(appFolder, platform string, toolchain Toolchain) (string, string, error) {
+	polycodeDir := getPolycodeDir()
+
+	dockerfile, err := os.ReadFile(filepath.Join(polycodeDir, "Dockerfile"))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read Dockerfile: %w", err)
+	}
+	entrypoint, err := os.ReadFile(filepath.Join(polycodeDir, "entrypoint.sh"))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read entrypoint.sh: %w", err)
+	}
+	debugRun, err := os.ReadFile(filepath.Join(polycodeDir, "debug-run.sh"))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read debug-run.sh: %w", err)
+	}
+	control, err := os.ReadFile(filepath.Join(polycodeDir, "control.sh"))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read control.sh: %w", err)
+	}
+	sidecarChecksum, err := os.ReadFile(filepath.Join(polycodeDir, "runtime", "sidecar.checksum"))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read sidecar checksum: %w", err)
+	}
+
+	baseDigest, err := toolchain.baseImageDigest()
+	if err != nil {
+		return "", "", err
+	}
+
+	h := sha256.New()
+	for _, part := range [][]byte{
+		dockerfile,
+		entrypoint,
+		debugRun,
+		control,
+		[]byte(strings.TrimSpace(string(sidecarChecksum))),
+		[]byte(baseDigest),
+		[]byte(toolchain.BuilderImage),
+		[]byte(toolchain.Dlv),
+		[]byte(toolchain.PolyWatcher),
+		[]byte(appFolder),
+		[]byte(platform),
+	} {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), baseDigest, nil
+}
+
+func fingerprintPath(imageTag string) string {
+	return filepath.Join(getCacheDir(), cacheName(imageTag)+".fingerprint")
+}
+
+// upToDate reports whether imageTag was built from the given fingerprint and
+// still exists locally.
+func upToDate(imageTag, fingerprint string) bool {
+	data, err := os.ReadFile(fingerprintPath(imageTag))
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(data)) == fingerprint && imageExists(imageTag)
+}
+
+// cacheArgs returns the --cache-from/--cache-to flags for a local cache
+// export. BuildKit never prunes a local cache in place, so each build writes
+// a fresh directory that replaces the previous one afterwards.
+func cacheArgs(imageTag string) (args []string, commit func() error) {
+	dir := filepath.Join(getCacheDir(), cacheName(imageTag))
+	next := dir + ".new"
+
+	if _, err := os.Stat(dir); err == nil {
+		args = append(args, "--cache-from", "type=local,src="+dir)
+	}
+	args = append(args, "--cache-to", "type=local,mode=max,dest="+next)
+
+	commit = func() error {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove old build cache: %w", err)
+		}
+		if err := os.Rename(next, dir); err != nil {
+			return fmt.Errorf("failed to replace build cache: %w", err)
+		}
+		return nil
+	}
+	return args, commit
+}
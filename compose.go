@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// labelConfigHash records the configuration a compose container was created
+// with, so up only recreates the services that changed.
+const labelConfigHash = "com.docker.compose.config-hash"
+
+// composeProject is the part of a compose file the polycode stacks use,
+// enough to bring them up and down through the engine API.
+type composeProject struct {
+	Name     string
+	Dir      string
+	Platform string
+	Networks map[string]composeNetwork `yaml:"networks"`
+	Services map[string]composeService `yaml:"services"`
+}
+
+type composeNetwork struct {
+	Name     string `yaml:"name"`
+	External bool   `yaml:"external"`
+}
+
+type composeService struct {
+	Image       string            `yaml:"image"`
+	Networks    []string          `yaml:"networks"`
+	Ports       []string          `yaml:"ports"`
+	Command     composeCommand    `yaml:"command"`
+	Environment map[string]string `yaml:"environment"`
+	Volumes     []string          `yaml:"volumes"`
+	DependsOn   []string          `yaml:"depends_on"`
+	Restart     string            `yaml:"restart"`
+	PullPolicy  string            `yaml:"pull_policy"`
+}
+
+// composeCommand is a command given as a list or as a shell-quoted string.
+type composeCommand []string
+
+func (c *composeCommand) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		words, err := splitShellWords(n.Value)
+		*c = words
+		return err
+	}
+	var list []string
+	if err := n.Decode(&list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+// splitShellWords splits s into words the way a POSIX shell does, without
+// expanding anything.
+func splitShellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' {
+				escaped = true
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote in '%s'", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// loadComposeProject reads a compose file of the polycode dir, interpolating
+// it with the process environment overlaid with env as compose does.
+func loadComposeProject(project, composeFile string, env []string) (*composeProject, error) {
+	dir := getPolycodeDir()
+	data, err := os.ReadFile(filepath.Join(dir, composeFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", composeFile, err)
+	}
+
+	vars := map[string]string{}
+	for _, kv := range append(os.Environ(), env...) {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			vars[k] = v
+		}
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", composeFile, err)
+	}
+	interpolateNode(&root, vars)
+
+	p := &composeProject{Name: project, Dir: dir, Platform: vars["DOCKER_DEFAULT_PLATFORM"]}
+	if err := root.Decode(p); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", composeFile, err)
+	}
+	for name, s := range p.Services {
+		if s.Image == "" {
+			return nil, fmt.Errorf("%s: service %s has no image", composeFile, name)
+		}
+	}
+	return p, nil
+}
+
+// interpolateNode expands ${VAR}, ${VAR:-default} and ${VAR-default} in
+// every scalar value, after parsing so a value cannot change the structure.
+func interpolateNode(n *yaml.Node, vars map[string]string) {
+	if n.Kind == yaml.ScalarNode {
+		n.Value = os.Expand(n.Value, func(expr string) string {
+			if expr == "$" {
+				return "$"
+			}
+			if name, def, ok := strings.Cut(expr, ":-"); ok {
+				return firstNonEmpty(vars[name], def)
+			}
+			if name, def, ok := strings.Cut(expr, "-"); ok {
+				if v, set := vars[name]; set {
+					return v
+				}
+				return def
+			}
+			return vars[expr]
+		})
+		return
+	}
+	for _, c := range n.Content {
+		interpolateNode(c, vars)
+	}
+}
+
+// networkName returns the engine name of a network of the file.
+func (p *composeProject) networkName(key string) string {
+	if n := p.Networks[key]; n.Name != "" {
+		return n.Name
+	}
+	if p.Networks[key].External {
+		return key
+	}
+	return p.Name + "_" + key
+}
+
+func (p *composeProject) containerName(service string) string {
+	return p.Name + "-" + service + "-1"
+}
+
+// serviceOrder returns the services with every dependency before the
+// services depending on it.
+func (p *composeProject) serviceOrder() ([]string, error) {
+	names := make([]string, 0, len(p.Services))
+	for name := range p.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var order []string
+	state := map[string]int{} // 1 visiting, 2 done
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("dependency cycle at service %s", name)
+		case 2:
+			return nil
+		}
+		if _, ok := p.Services[name]; !ok {
+			return fmt.Errorf("unknown service %s in depends_on", name)
+		}
+		state[name] = 1
+		for _, dep := range p.Services[name].DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// containerSpec returns the container of a service.
+func (p *composeProject) containerSpec(service string) (containerSpec, error) {
+	s := p.Services[service]
+	spec := containerSpec{
+		Name:     p.containerName(service),
+		Image:    s.Image,
+		Platform: p.Platform,
+		Cmd:      s.Command,
+		Restart:  s.Restart,
+		Labels: map[string]string{
+			labelComposeProject:                   p.Name,
+			labelComposeService:                   service,
+			"com.docker.compose.oneoff":           "False",
+			"com.docker.compose.container-number": "1",
+		},
+	}
+	if len(s.Networks) > 0 {
+		spec.Network = p.networkName(s.Networks[0])
+		spec.Aliases = []string{service}
+	}
+
+	keys := make([]string, 0, len(s.Environment))
+	for k := range s.Environment {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		spec.Env = append(spec.Env, k+"="+s.Environment[k])
+	}
+
+	for _, port := range s.Ports {
+		m, err := parsePortMapping(port)
+		if err != nil {
+			return spec, fmt.Errorf("service %s: %w", service, err)
+		}
+		spec.Ports = append(spec.Ports, m)
+	}
+
+	for _, v := range s.Volumes {
+		src, dst, ok := strings.Cut(v, ":")
+		if !ok {
+			return spec, fmt.Errorf("service %s: anonymous volume '%s' is not supported", service, v)
+		}
+		if strings.HasPrefix(src, ".") {
+			src = filepath.Join(p.Dir, src)
+		}
+		spec.Binds = append(spec.Binds, src+":"+dst)
+	}
+	return spec, nil
+}
+
+// configHash identifies what a service container was created from,
+// including the image it resolved to.
+func configHash(spec containerSpec, imageID string) string {
+	data, _ := json.Marshal(struct {
+		Spec    containerSpec
+		ImageID string
+	}{spec, imageID})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// composeUp creates and starts the services of p that are missing, stopped
+// or changed, and every service when force is set. auths maps registry
+// hosts to their X-Registry-Auth value.
+func composeUp(ctx context.Context, api *APIClient, p *composeProject, force bool, auths map[string]string) error {
+	for key, n := range p.Networks {
+		name := p.networkName(key)
+		exists, err := api.NetworkExists(ctx, name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if n.External {
+			return fmt.Errorf("external network %s does not exist", name)
+		}
+		if err := api.NetworkCreate(ctx, name, map[string]string{
+			labelComposeProject:          p.Name,
+			"com.docker.compose.network": key,
+		}); err != nil {
+			return err
+		}
+		fmt.Printf("   🌐 Network %s created\n", name)
+	}
+
+	order, err := p.serviceOrder()
+	if err != nil {
+		return err
+	}
+	for _, service := range order {
+		spec, err := p.containerSpec(service)
+		if err != nil {
+			return err
+		}
+		image, err := ensureImage(ctx, api, spec.Image, p.Platform, p.Services[service].PullPolicy, auths)
+		if err != nil {
+			return err
+		}
+		spec.Labels[labelConfigHash] = configHash(spec, image.ID)
+
+		existing, err := api.ContainerInspect(ctx, spec.Name)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		if existing != nil && !force && existing.Config.Labels[labelConfigHash] == spec.Labels[labelConfigHash] {
+			if existing.State.Running {
+				fmt.Printf("   ✅ %s running\n", spec.Name)
+				continue
+			}
+			if err := api.ContainerStart(ctx, spec.Name); err != nil {
+				return err
+			}
+			fmt.Printf("   ▶️  %s started\n", spec.Name)
+			continue
+		}
+
+		if existing != nil {
+			if err := api.ContainerStop(ctx, spec.Name, 10*time.Second); err != nil && !isNotFound(err) {
+				return err
+			}
+			if err := api.ContainerRemove(ctx, spec.Name); err != nil {
+				return err
+			}
+		}
+		if _, err := api.ContainerCreate(ctx, spec.Name, spec.Platform, spec.createConfig()); err != nil {
+			return err
+		}
+		if err := api.ContainerStart(ctx, spec.Name); err != nil {
+			return err
+		}
+		if existing != nil {
+			fmt.Printf("   🔄 %s recreated\n", spec.Name)
+		} else {
+			fmt.Printf("   🚀 %s created\n", spec.Name)
+		}
+	}
+	return nil
+}
+
+// ensureImage pulls ref when the pull policy asks for it or the image is
+// missing, and returns the local image.
+func ensureImage(ctx context.Context, api *APIClient, ref, platform, policy string, auths map[string]string) (*ImageDetails, error) {
+	image, err := api.ImageInspect(ctx, ref)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	if image != nil && policy != "always" {
+		return image, nil
+	}
+
+	fmt.Printf("   📥 Pulling %s\n", ref)
+	if err := api.ImagePull(ctx, ref, platform, auths[imageRegistry(ref)]); err != nil {
+		return nil, err
+	}
+	return api.ImageInspect(ctx, ref)
+}
+
+// imageRegistry returns the registry host of an image reference, empty for
+// Docker Hub.
+func imageRegistry(ref string) string {
+	host, _, ok := strings.Cut(ref, "/")
+	if ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		return host
+	}
+	return ""
+}
+
+// composeDown stops and removes the containers of project, then the
+// networks the file created. A network still used by another stack is kept.
+func composeDown(ctx context.Context, api *APIClient, p *composeProject) error {
+	containers, err := api.ProjectContainers(ctx, p.Name)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		if err := api.ContainerStop(ctx, c.ID, 10*time.Second); err != nil && !isNotFound(err) {
+			return err
+		}
+		if err := api.ContainerRemove(ctx, c.ID); err != nil {
+			return err
+		}
+		fmt.Printf("   🗑️  %s removed\n", c.Name())
+	}
+
+	for key, n := range p.Networks {
+		if n.External {
+			continue
+		}
+		name := p.networkName(key)
+		if err := api.NetworkRemove(ctx, name); err != nil {
+			warnf("network %s was kept: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// stackUp brings a stack of the polycode dir up through the engine API, or
+// through the compose CLI when the engine has no API socket. registry is
+// the registry the stack pulls private images from, if any.
+func stackUp(project, composeFile string, env []string, force bool, registry *RegistryConfig) error {
+	api := engineAPI()
+	if api == nil {
+		if registry != nil {
+			if err := loginDockerRegistries(*registry); err != nil {
+				return newCLIError(ErrRegistryAuthFailed, err)
+			}
+		}
+		args := []string{"-f", composeFile, "-p", project, "up", "-d"}
+		if force {
+			args = append(args, "--force-recreate")
+		}
+		cmd := containerEngine().Compose(args...)
+		cmd.Dir = getPolycodeDir()
+		cmd.Env = append(os.Environ(), env...)
+		if err := runCmd(cmd); err != nil {
+			return fmt.Errorf("docker compose failed: %w", err)
+		}
+		return nil
+	}
+
+	p, err := loadComposeProject(project, composeFile, env)
+	if err != nil {
+		return err
+	}
+	var auths map[string]string
+	if registry != nil {
+		if auths, err = registryAuths(*registry); err != nil {
+			return newCLIError(ErrRegistryAuthFailed, err)
+		}
+	}
+	return composeUp(context.Background(), api, p, force, auths)
+}
+
+// stackDown removes a stack of the polycode dir, through the engine API when
+// it is reachable.
+func stackDown(project, composeFile string, env []string) error {
+	api := engineAPI()
+	if api == nil {
+		cmd := containerEngine().Compose("-f", composeFile, "-p", project, "down")
+		cmd.Dir = getPolycodeDir()
+		cmd.Env = append(os.Environ(), env...)
+		if err := runCmd(cmd); err != nil {
+			return fmt.Errorf("docker compose failed: %w", err)
+		}
+		return nil
+	}
+
+	p, err := loadComposeProject(project, composeFile, env)
+	if err != nil {
+		return err
+	}
+	return composeDown(context.Background(), api, p)
+}
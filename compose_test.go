@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// installComposeFiles copies the stacks in resources/ into a fresh polycode
+// dir.
+func installComposeFiles(t *testing.T) string {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	dir := getPolycodeDir()
+	for _, f := range []string{"docker-compose-platform.yml", "docker-compose-env.yml"} {
+		data, err := os.ReadFile(filepath.Join("resources", f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, f), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadComposeProjectPlatform(t *testing.T) {
+	dir := installComposeFiles(t)
+	t.Setenv("POLYCODE_NATS_PORT", "14222")
+
+	p, err := loadComposeProject("polycode-platform", "docker-compose-platform.yml", []string{
+		"NATS_JETSTREAM_ARGS=-js --store_dir /data",
+		"DOCKER_DEFAULT_PLATFORM=linux/arm64",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := p.serviceOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"dynamodb", "nats", "s3"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+
+	nats, err := p.containerSpec("nats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := containerSpec{
+		Name:     "polycode-platform-nats-1",
+		Image:    "nats:latest",
+		Platform: "linux/arm64",
+		Network:  "polycode-dev",
+		Aliases:  []string{"nats"},
+		Cmd:      []string{"--config", "nats-server.conf", "-js", "--store_dir", "/data"},
+		Labels: map[string]string{
+			labelComposeProject:                   "polycode-platform",
+			labelComposeService:                   "nats",
+			"com.docker.compose.oneoff":           "False",
+			"com.docker.compose.container-number": "1",
+		},
+		Ports: []portMapping{
+			{HostIP: "0.0.0.0", HostPort: 14222, ContainerPort: 4222, Protocol: "tcp"},
+			{HostIP: "0.0.0.0", HostPort: 8222, ContainerPort: 8222, Protocol: "tcp"},
+		},
+		Binds:   []string{filepath.Join(dir, "data", "nats") + ":/data"},
+		Restart: "unless-stopped",
+	}
+	if !reflect.DeepEqual(nats, want) {
+		t.Errorf("nats = %+v\nwant %+v", nats, want)
+	}
+
+	s3, err := p.containerSpec("s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"server", "/data", "--console-address", ":9001"}; !reflect.DeepEqual([]string(s3.Cmd), want) {
+		t.Errorf("s3 command = %q, want %q", s3.Cmd, want)
+	}
+}
+
+func TestLoadComposeProjectEnv(t *testing.T) {
+	installComposeFiles(t)
+
+	p, err := loadComposeProject("polycode-env-dev", "docker-compose-env.yml", []string{
+		"ENVIRONMENT_ID=dev",
+		"POLYCODE_APPS_REGISTRY=123456789012.dkr.ecr.eu-west-1.amazonaws.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.networkName("polycode-dev"); got != "polycode-dev" {
+		t.Errorf("network = %s, want polycode-dev", got)
+	}
+
+	spec, err := p.containerSpec("next-agent-runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := imageRegistry(spec.Image), "123456789012.dkr.ecr.eu-west-1.amazonaws.com"; got != want {
+		t.Errorf("registry = %s, want %s", got, want)
+	}
+	env := map[string]bool{}
+	for _, e := range spec.Env {
+		env[e] = true
+	}
+	for _, e := range []string{"polycode_ENV_ID=dev", "polycode_ORG_ID=xxx", "polycode_ENV_EXTRACTOR=shared agent"} {
+		if !env[e] {
+			t.Errorf("env lacks %s: %v", e, spec.Env)
+		}
+	}
+}
+
+func TestSplitShellWords(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`server /data --console-address ":9001"`, []string{"server", "/data", "--console-address", ":9001"}},
+		{`--config nats-server.conf `, []string{"--config", "nats-server.conf"}},
+		{`sh -c 'echo "a b"'`, []string{"sh", "-c", `echo "a b"`}},
+		{`a\ b ""`, []string{"a b", ""}},
+	}
+	for _, tt := range tests {
+		got, err := splitShellWords(tt.input)
+		if err != nil {
+			t.Errorf("splitShellWords(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitShellWords(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	if _, err := splitShellWords(`echo "unterminated`); err == nil {
+		t.Error("expected an error for an unterminated quote")
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ServiceStatus is the state of one container of a compose project.
+type ServiceStatus struct {
+	Name       string      `json:"Name"`
+	Service    string      `json:"Service"`
+	State      string      `json:"State"`
+	Health     string      `json:"Health"`
+	ExitCode   int         `json:"ExitCode"`
+	Publishers []Publisher `json:"Publishers"`
+}
+
+// Publisher is a published port of a container.
+type Publisher struct {
+	URL           string `json:"URL"`
+	TargetPort    int    `json:"TargetPort"`
+	PublishedPort int    `json:"PublishedPort"`
+	Protocol      string `json:"Protocol"`
+}
+
+// parseComposePS parses `compose ps --format json`. Compose up to 2.20
+// prints one JSON array, later versions one object per line.
+func parseComposePS(data []byte) ([]ServiceStatus, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	if data[0] == '[' {
+		var services []ServiceStatus
+		if err := json.Unmarshal(data, &services); err != nil {
+			return nil, fmt.Errorf("failed to parse compose ps output: %w", err)
+		}
+		return services, nil
+	}
+
+	var services []ServiceStatus
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var svc ServiceStatus
+		if err := json.Unmarshal(line, &svc); err != nil {
+			return nil, fmt.Errorf("failed to parse compose ps output: %w", err)
+		}
+		services = append(services, svc)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read compose ps output: %w", err)
+	}
+	return services, nil
+}
+
+var (
+	healthPattern   = regexp.MustCompile(`\((healthy|unhealthy|health: starting)\)`)
+	exitCodePattern = regexp.MustCompile(`^Exited \((\d+)\)`)
+)
+
+// statusFromSummary maps an API container summary to a ServiceStatus. The
+// list endpoint only reports health and exit code inside the Status text.
+func statusFromSummary(c ContainerSummary) ServiceStatus {
+	svc := ServiceStatus{
+		Name:    c.Name(),
+		Service: c.Labels[labelComposeService],
+		State:   c.State,
+	}
+	if m := healthPattern.FindStringSubmatch(c.Status); m != nil {
+		svc.Health = strings.TrimPrefix(m[1], "health: ")
+	}
+	if m := exitCodePattern.FindStringSubmatch(c.Status); m != nil {
+		svc.ExitCode, _ = strconv.Atoi(m[1])
+	}
+	for _, p := range c.Ports {
+		if p.PublicPort == 0 {
+			continue
+		}
+		svc.Publishers = append(svc.Publishers, Publisher{
+			URL:           p.IP,
+			TargetPort:    p.PrivatePort,
+			PublishedPort: p.PublicPort,
+			Protocol:      p.Type,
+		})
+	}
+	return svc
+}
+
+// projectStatus returns the state of every container of a compose project,
+// through the engine API when it is reachable and from `compose ps`
+// otherwise.
+func projectStatus(project, composeFile string, env []string) ([]ServiceStatus, error) {
+	var services []ServiceStatus
+
+	if api := engineAPI(); api != nil {
+		containers, err := api.ProjectContainers(context.Background(), project)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range containers {
+			services = append(services, statusFromSummary(c))
+		}
+	} else {
+		cmd := containerEngine().Compose(
+			"-f", composeFile,
+			"-p", project,
+			"ps", "--all", "--format", "json")
+		cmd.Dir = getPolycodeDir()
+		cmd.Env = append(os.Environ(), env...)
+
+		out, err := cmdOutput(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("compose ps failed: %w", err)
+		}
+		if services, err = parseComposePS(out); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(services, func(i, j int) bool { return services[i].Service < services[j].Service })
+	return services, nil
+}
+
+func anyRunning(services []ServiceStatus) bool {
+	for _, svc := range services {
+		if strings.EqualFold(svc.State, "running") {
+			return true
+		}
+	}
+	return false
+}
+
+// stackInputs names the groups of variables a stack is started with, so a
+// recreate can say which of them changed.
+type stackInputs map[string][]string
+
+// env returns every variable of the inputs.
+func (in stackInputs) env() []string {
+	names := make([]string, 0, len(in))
+	for name := range in {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var env []string
+	for _, name := range names {
+		env = append(env, in[name]...)
+	}
+	return env
+}
+
+// stackStamp hashes the compose file and each input of a stack. Only hashes
+// are stored, the inputs hold credentials.
+func stackStamp(composeFile string, in stackInputs) map[string]string {
+	hash := func(parts ...string) string {
+		h := sha256.New()
+		for _, p := range parts {
+			h.Write([]byte(p + "\x00"))
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	data, _ := os.ReadFile(filepath.Join(getPolycodeDir(), composeFile))
+	stamp := map[string]string{composeFile: hash(string(data))}
+	for name, env := range in {
+		sorted := append([]string{}, env...)
+		sort.Strings(sorted)
+		stamp[name] = hash(sorted...)
+	}
+	return stamp
+}
+
+func stackStampPath(project string) string {
+	return filepath.Join(getPolycodeDir(), project+".stamp.json")
+}
+
+// stackChanges returns the inputs that differ from the ones the running
+// stack was started with; known is false when that was not recorded.
+func stackChanges(project, composeFile string, in stackInputs) (changed []string, known bool) {
+	var saved map[string]string
+	data, err := os.ReadFile(stackStampPath(project))
+	if err != nil || json.Unmarshal(data, &saved) != nil {
+		return nil, false
+	}
+	for name, sum := range stackStamp(composeFile, in) {
+		if saved[name] != sum {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed, true
+}
+
+func saveStackStamp(project, composeFile string, in stackInputs) {
+	data, _ := json.MarshalIndent(stackStamp(composeFile, in), "", "  ")
+	if err := os.WriteFile(stackStampPath(project), data, 0600); err != nil {
+		warnf("Failed to record the settings %s started with, its next start recreates it: %v", project, err)
+	}
+}
+
+// recreateReason says why a running stack is started again.
+func recreateReason(what string, changed []string, known bool) string {
+	if !known {
+		return fmt.Sprintf("🔄 No record of the settings the %s started with, recreating it...", what)
+	}
+	return fmt.Sprintf("🔄 Changed since the %s started: %s. Recreating it...", what, strings.Join(changed, ", "))
+}
+
+// summarizeStatus condenses the services of a project into RUNNING, ERROR
+// or STOPPED.
+func summarizeStatus(services []ServiceStatus) string {
+	if len(services) == 0 {
+		return "STOPPED"
+	}
+
+	allRunning := true
+	anyFailed := false
+
+	for _, svc := range services {
+		switch strings.ToLower(svc.State) {
+		case "running":
+			if svc.Health == "unhealthy" {
+				anyFailed = true
+			}
+		case "exited", "dead", "removing":
+			anyFailed = true
+			allRunning = false
+		default:
+			allRunning = false
+		}
+	}
+
+	switch {
+	case anyFailed:
+		return "ERROR"
+	case allRunning:
+		return "RUNNING"
+	}
+	return "STOPPED"
+}
+
+func printProjectStatus(services []ServiceStatus) {
+	fmt.Println(summarizeStatus(services))
+
+	for _, svc := range services {
+		state := svc.State
+		if svc.Health != "" {
+			state += " (" + svc.Health + ")"
+		}
+		if strings.EqualFold(svc.State, "exited") {
+			state += fmt.Sprintf(" (exit %d)", svc.ExitCode)
+		}
+
+		// IPv4 and IPv6 bindings of a port are listed separately
+		var ports []string
+		seen := map[string]bool{}
+		for _, p := range svc.Publishers {
+			port := fmt.Sprintf("%d->%d/%s", p.PublishedPort, p.TargetPort, p.Protocol)
+			if p.PublishedPort != 0 && !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+		fmt.Printf("  %-20s %-24s %s\n", firstNonEmpty(svc.Service, svc.Name), state, strings.Join(ports, ", "))
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// `compose ps --all --format json` output for the stacks in resources/.
+// Compose up to 2.20 prints one JSON array, 2.21 and later one object per
+// line.
+const (
+	// docker-compose-platform.yml, compose 2.17
+	platformPSArray = `[{"ID":"3f2a9c1d7b40","Name":"polycode-platform-dynamodb-1","Image":"amazon/dynamodb-local","Command":"java -jar DynamoDBLocal.jar -sharedDb -dbPath /data","Project":"polycode-platform","Service":"dynamodb","Created":1718013600,"State":"running","Status":"Up 2 minutes","Health":"","ExitCode":0,"Publishers":[{"URL":"0.0.0.0","TargetPort":8000,"PublishedPort":8000,"Protocol":"tcp"}]},{"ID":"8d41e0b2c9a7","Name":"polycode-platform-nats-1","Image":"nats:latest","Command":"/nats-server --config nats-server.conf","Project":"polycode-platform","Service":"nats","Created":1718013600,"State":"running","Status":"Up 2 minutes","Health":"","ExitCode":0,"Publishers":[{"URL":"0.0.0.0","TargetPort":4222,"PublishedPort":4222,"Protocol":"tcp"},{"URL":"","TargetPort":6222,"PublishedPort":0,"Protocol":"tcp"},{"URL":"0.0.0.0","TargetPort":8222,"PublishedPort":8222,"Protocol":"tcp"}]},{"ID":"c07be5513f6e","Name":"polycode-platform-s3-1","Image":"minio/minio","Command":"/usr/bin/docker-entrypoint.sh server /data --console-address :9001","Project":"polycode-platform","Service":"s3","Created":1718013600,"State":"running","Status":"Up 2 minutes","Health":"","ExitCode":0,"Publishers":[{"URL":"0.0.0.0","TargetPort":9000,"PublishedPort":9000,"Protocol":"tcp"},{"URL":"0.0.0.0","TargetPort":9001,"PublishedPort":9001,"Protocol":"tcp"}]}]`
+
+	// docker-compose-env.yml for environment "dev", compose 2.24
+	envPSLines = `{"Command":"\"/var/task/bootstrap-fargate.sh\"","CreatedAt":"2024-06-10 10:00:00 +0000 UTC","ExitCode":0,"Health":"","ID":"1b7c0e93a2d4","Image":"537413656254.dkr.ecr.us-east-1.amazonaws.com/cloudimpl/xxx/next-env:latest","Labels":"","LocalVolumes":"0","Mounts":"/root/.polycode/runtime","Name":"polycode-env-dev-next-env-1","Names":"polycode-env-dev-next-env-1","Networks":"polycode-dev","Ports":"","Project":"polycode-env-dev","Publishers":[],"RunningFor":"1 minute ago","Service":"next-env","Size":"0B","State":"running","Status":"Up 1 minute"}
+{"Command":"\"/var/task/bootstrap-fargate.sh\"","CreatedAt":"2024-06-10 10:00:00 +0000 UTC","ExitCode":1,"Health":"","ID":"5e9d2f7a0c18","Image":"485496110001.dkr.ecr.us-east-1.amazonaws.com/485496110001/h7npshowhzdc5d/app-u6fj1h32637699:latest","Labels":"","LocalVolumes":"0","Mounts":"/root/.polycode/runtime","Name":"polycode-env-dev-next-agent-runtime-1","Names":"polycode-env-dev-next-agent-runtime-1","Networks":"polycode-dev","Ports":"","Project":"polycode-env-dev","Publishers":[],"RunningFor":"1 minute ago","Service":"next-agent-runtime","Size":"0B","State":"restarting","Status":"Restarting (1) 4 seconds ago"}
+{"Command":"\"/var/task/bootstrap-fargate.sh\"","CreatedAt":"2024-06-10 10:00:00 +0000 UTC","ExitCode":137,"Health":"","ID":"a4c83b61e05f","Image":"485496110001.dkr.ecr.us-east-1.amazonaws.com/485496110001/h7npshowhzdc5d/app-xxor0ebrq8q2wg:latest","Labels":"","LocalVolumes":"0","Mounts":"/root/.polycode/runtime","Name":"polycode-env-dev-next-ai-gateway-1","Names":"polycode-env-dev-next-ai-gateway-1","Networks":"polycode-dev","Ports":"","Project":"polycode-env-dev","Publishers":null,"RunningFor":"1 minute ago","Service":"next-ai-gateway","Size":"0B","State":"exited","Status":"Exited (137) 10 seconds ago"}
+`
+
+	// GET /containers/json?all=1 for the platform project
+	platformContainersJSON = `[{"Id":"8d41e0b2c9a7","Names":["/polycode-platform-nats-1"],"Image":"nats:latest","State":"running","Status":"Up 5 seconds (health: starting)","Labels":{"com.docker.compose.project":"polycode-platform","com.docker.compose.service":"nats"},"Ports":[{"PrivatePort":6222,"Type":"tcp"},{"IP":"0.0.0.0","PrivatePort":4222,"PublicPort":4222,"Type":"tcp"},{"IP":"0.0.0.0","PrivatePort":8222,"PublicPort":8222,"Type":"tcp"}]},{"Id":"c07be5513f6e","Names":["/polycode-platform-s3-1"],"Image":"minio/minio","State":"running","Status":"Up 2 minutes (unhealthy)","Labels":{"com.docker.compose.project":"polycode-platform","com.docker.compose.service":"s3"},"Ports":[{"IP":"127.0.0.1","PrivatePort":9000,"PublicPort":9000,"Type":"tcp"}]},{"Id":"3f2a9c1d7b40","Names":["/polycode-platform-dynamodb-1"],"Image":"amazon/dynamodb-local","State":"exited","Status":"Exited (143) 3 seconds ago","Labels":{"com.docker.compose.project":"polycode-platform","com.docker.compose.service":"dynamodb"},"Ports":[]},{"Id":"e61f0d2b8c33","Names":[],"Image":"nats:latest","State":"created","Status":"Created","Labels":{},"Ports":null}]`
+)
+
+func TestParseComposePS(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []ServiceStatus
+	}{
+		{
+			name:  "empty output",
+			input: "",
+			want:  nil,
+		},
+		{
+			name:  "only whitespace",
+			input: "\n  \n",
+			want:  nil,
+		},
+		{
+			name:  "empty array",
+			input: "[]\n",
+			want:  []ServiceStatus{},
+		},
+		{
+			name:  "JSON array of the platform stack",
+			input: platformPSArray,
+			want: []ServiceStatus{
+				{
+					Name:    "polycode-platform-dynamodb-1",
+					Service: "dynamodb",
+					State:   "running",
+					Publishers: []Publisher{
+						{URL: "0.0.0.0", TargetPort: 8000, PublishedPort: 8000, Protocol: "tcp"},
+					},
+				},
+				{
+					Name:    "polycode-platform-nats-1",
+					Service: "nats",
+					State:   "running",
+					Publishers: []Publisher{
+						{URL: "0.0.0.0", TargetPort: 4222, PublishedPort: 4222, Protocol: "tcp"},
+						{URL: "", TargetPort: 6222, PublishedPort: 0, Protocol: "tcp"},
+						{URL: "0.0.0.0", TargetPort: 8222, PublishedPort: 8222, Protocol: "tcp"},
+					},
+				},
+				{
+					Name:    "polycode-platform-s3-1",
+					Service: "s3",
+					State:   "running",
+					Publishers: []Publisher{
+						{URL: "0.0.0.0", TargetPort: 9000, PublishedPort: 9000, Protocol: "tcp"},
+						{URL: "0.0.0.0", TargetPort: 9001, PublishedPort: 9001, Protocol: "tcp"},
+					},
+				},
+			},
+		},
+		{
+			name:  "NDJSON of the env stack with trailing newline",
+			input: envPSLines,
+			want: []ServiceStatus{
+				{
+					Name:       "polycode-env-dev-next-env-1",
+					Service:    "next-env",
+					State:      "running",
+					Publishers: []Publisher{},
+				},
+				{
+					Name:       "polycode-env-dev-next-agent-runtime-1",
+					Service:    "next-agent-runtime",
+					State:      "restarting",
+					ExitCode:   1,
+					Publishers: []Publisher{},
+				},
+				{
+					Name:     "polycode-env-dev-next-ai-gateway-1",
+					Service:  "next-ai-gateway",
+					State:    "exited",
+					ExitCode: 137,
+				},
+			},
+		},
+		{
+			name:  "single NDJSON line without trailing newline",
+			input: `{"Name":"polycode-platform-dynamodb-1","Service":"dynamodb","State":"exited","ExitCode":1,"Publishers":[]}`,
+			want: []ServiceStatus{
+				{
+					Name:       "polycode-platform-dynamodb-1",
+					Service:    "dynamodb",
+					State:      "exited",
+					ExitCode:   1,
+					Publishers: []Publisher{},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseComposePS([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("parseComposePS() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseComposePS() =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseComposePSInvalid(t *testing.T) {
+	for _, input := range []string{
+		`[{"Name":"broken"`,
+		"{\"Name\":\"ok\"}\nnot json\n",
+	} {
+		if _, err := parseComposePS([]byte(input)); err == nil {
+			t.Errorf("parseComposePS(%q) succeeded, want an error", input)
+		}
+	}
+}
+
+func TestStatusFromSummary(t *testing.T) {
+	var containers []ContainerSummary
+	if err := json.Unmarshal([]byte(platformContainersJSON), &containers); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ServiceStatus{
+		{
+			Name:    "polycode-platform-nats-1",
+			Service: "nats",
+			State:   "running",
+			Health:  "starting",
+			Publishers: []Publisher{
+				{URL: "0.0.0.0", TargetPort: 4222, PublishedPort: 4222, Protocol: "tcp"},
+				{URL: "0.0.0.0", TargetPort: 8222, PublishedPort: 8222, Protocol: "tcp"},
+			},
+		},
+		{
+			Name:    "polycode-platform-s3-1",
+			Service: "s3",
+			State:   "running",
+			Health:  "unhealthy",
+			Publishers: []Publisher{
+				{URL: "127.0.0.1", TargetPort: 9000, PublishedPort: 9000, Protocol: "tcp"},
+			},
+		},
+		{
+			Name:     "polycode-platform-dynamodb-1",
+			Service:  "dynamodb",
+			State:    "exited",
+			ExitCode: 143,
+		},
+		{
+			Name:  "e61f0d2b8c33",
+			State: "created",
+		},
+	}
+
+	if len(containers) != len(want) {
+		t.Fatalf("decoded %d containers, want %d", len(containers), len(want))
+	}
+	for i, c := range containers {
+		if got := statusFromSummary(c); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("statusFromSummary(%s) =\n%#v\nwant\n%#v", c.ID, got, want[i])
+		}
+	}
+}
+
+func TestSummarizeStatus(t *testing.T) {
+	running := func(service, health string) ServiceStatus {
+		return ServiceStatus{Service: service, State: "running", Health: health}
+	}
+
+	tests := []struct {
+		name     string
+		services []ServiceStatus
+		want     string
+	}{
+		{"no containers", nil, "STOPPED"},
+		{"all running", []ServiceStatus{running("dynamodb", ""), running("nats", ""), running("s3", "healthy")}, "RUNNING"},
+		{"health check still starting", []ServiceStatus{running("nats", "starting")}, "RUNNING"},
+		{"unhealthy service", []ServiceStatus{running("dynamodb", ""), running("s3", "unhealthy")}, "ERROR"},
+		{"exited service", []ServiceStatus{running("nats", ""), {Service: "dynamodb", State: "exited", ExitCode: 143}}, "ERROR"},
+		{"exited with code 0", []ServiceStatus{{Service: "s3", State: "exited"}}, "ERROR"},
+		{"dead service", []ServiceStatus{{Service: "s3", State: "dead"}}, "ERROR"},
+		{"created but not started", []ServiceStatus{running("nats", ""), {Service: "s3", State: "created"}}, "STOPPED"},
+		{"state is matched case-insensitively", []ServiceStatus{{Service: "s3", State: "Running"}}, "RUNNING"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeStatus(tt.services); got != tt.want {
+				t.Errorf("summarizeStatus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Config holds CLI-wide settings stored in ~/.polycode/config.json.
+type Config struct {
+	// Engine is the container engine: docker, podman or nerdctl. Empty
+	// detects one.
+	Engine    string    `json:"engine,omitempty"`
+	Toolchain Toolchain `json:"toolchain"`
+	Nats      struct {
+		JetStream bool `json:"jetstream,omitempty"`
+	} `json:"nats"`
+	Files struct {
+		EventsSubject string `json:"eventsSubject,omitempty"`
+		EventPrefixes string `json:"eventPrefixes,omitempty"`
+	} `json:"files"`
+	Ports  PortConfig `json:"ports"`
+	Remote struct {
+		// Tunnel reaches the platform of a remote DOCKER_HOST through
+		// `polycode remote tunnel` instead of the host's address.
+		Tunnel bool `json:"tunnel,omitempty"`
+	} `json:"remote"`
+	Platform struct {
+		LocalhostOnly bool   `json:"localhostOnly,omitempty"`
+		CORSOrigins   string `json:"corsOrigins,omitempty"`
+	} `json:"platform"`
+}
+
+func configPath() string {
+	return filepath.Join(getPolycodeDir(), "config.json")
+}
+
+func loadConfig() (*Config, error) {
+	cfg := &Config{}
+
+	data, err := os.ReadFile(configPath())
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return cfg, nil
+}
+
+func saveConfig(cfg *Config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := os.WriteFile(configPath(), data, 0644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+type configField struct {
+	get func() string
+	set func(string) error
+}
+
+func stringField(p *string) configField {
+	return configField{
+		get: func() string { return *p },
+		set: func(v string) error { *p = v; return nil },
+	}
+}
+
+func intField(p *int) configField {
+	return configField{
+		get: func() string { return strconv.Itoa(*p) },
+		set: func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > 65535 {
+				return fmt.Errorf("expected a port number, got '%s'", v)
+			}
+			*p = n
+			return nil
+		},
+	}
+}
+
+func boolField(p *bool) configField {
+	return configField{
+		get: func() string { return strconv.FormatBool(*p) },
+		set: func(v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("expected true or false, got '%s'", v)
+			}
+			*p = b
+			return nil
+		},
+	}
+}
+
+// configKeys maps the keys accepted by `polycode config` to their fields.
+func configKeys(cfg *Config) map[string]configField {
+	return map[string]configField{
+		"engine":                 stringField(&cfg.Engine),
+		"toolchain.baseImage":    stringField(&cfg.Toolchain.BaseImage),
+		"toolchain.dlv":          stringField(&cfg.Toolchain.Dlv),
+		"toolchain.polyWatcher":  stringField(&cfg.Toolchain.PolyWatcher),
+		"nats.jetstream":         boolField(&cfg.Nats.JetStream),
+		"files.eventsSubject":    stringField(&cfg.Files.EventsSubject),
+		"files.eventPrefixes":    stringField(&cfg.Files.EventPrefixes),
+		"platform.localhostOnly": boolField(&cfg.Platform.LocalhostOnly),
+		"ports.dynamodb":         intField(&cfg.Ports.DynamoDB),
+		"ports.s3":               intField(&cfg.Ports.S3),
+		"ports.console":          intField(&cfg.Ports.Console),
+		"ports.nats":             intField(&cfg.Ports.Nats),
+		"ports.natsMonitor":      intField(&cfg.Ports.NatsMonitor),
+		"ports.debug":            intField(&cfg.Ports.Debug),
+		"remote.tunnel":          boolField(&cfg.Remote.Tunnel),
+		"platform.corsOrigins":   stringField(&cfg.Platform.CORSOrigins),
+	}
+}
+
+func setConfig(key, value string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	field, ok := configKeys(cfg)[key]
+	if !ok {
+		return fmt.Errorf("unknown config key '%s'", key)
+	}
+	if err := field.set(value); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ %s = %s\n", key, value)
+	return nil
+}
+
+func listConfig() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	keys := configKeys(cfg)
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("%s = %s\n", name, keys[name].get())
+	}
+	return nil
+}
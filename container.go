@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// containerSpec is a container the CLI starts, created through the engine
+// API or turned into `run` flags for engines without one.
+type containerSpec struct {
+	Name        string
+	Image       string
+	Platform    string
+	Network     string
+	Aliases     []string
+	Cmd         []string
+	Env         []string
+	Labels      map[string]string
+	Ports       []portMapping
+	Binds       []string
+	ExtraHosts  []string
+	CapAdd      []string
+	SecurityOpt []string
+	Restart     string
+	AutoRemove  bool
+	Tty         bool
+}
+
+// portMapping publishes ContainerPort on HostIP:HostPort.
+type portMapping struct {
+	HostIP        string
+	HostPort      int
+	ContainerPort int
+	Protocol      string
+}
+
+func (p portMapping) key() string {
+	return fmt.Sprintf("%d/%s", p.ContainerPort, firstNonEmpty(p.Protocol, "tcp"))
+}
+
+// parsePortMapping parses the short compose form: [[ip:]host:]container[/proto].
+func parsePortMapping(s string) (portMapping, error) {
+	var p portMapping
+	spec, proto, _ := strings.Cut(s, "/")
+	p.Protocol = firstNonEmpty(proto, "tcp")
+
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 {
+		return p, fmt.Errorf("invalid port mapping '%s'", s)
+	}
+	var err error
+	if p.ContainerPort, err = strconv.Atoi(parts[len(parts)-1]); err != nil {
+		return p, fmt.Errorf("invalid port mapping '%s'", s)
+	}
+	if len(parts) >= 2 {
+		if p.HostPort, err = strconv.Atoi(parts[len(parts)-2]); err != nil {
+			return p, fmt.Errorf("invalid port mapping '%s'", s)
+		}
+	}
+	if len(parts) == 3 {
+		p.HostIP = parts[0]
+	}
+	return p, nil
+}
+
+// createConfig returns the body of the API create request.
+func (s containerSpec) createConfig() *ContainerConfig {
+	cfg := &ContainerConfig{
+		Image:  s.Image,
+		Cmd:    s.Cmd,
+		Env:    s.Env,
+		Labels: s.Labels,
+		Tty:    s.Tty,
+	}
+	cfg.HostConfig.Binds = s.Binds
+	cfg.HostConfig.ExtraHosts = s.ExtraHosts
+	cfg.HostConfig.CapAdd = s.CapAdd
+	cfg.HostConfig.SecurityOpt = s.SecurityOpt
+	cfg.HostConfig.AutoRemove = s.AutoRemove
+	cfg.HostConfig.RestartPolicy.Name = s.Restart
+
+	if len(s.Ports) > 0 {
+		cfg.ExposedPorts = map[string]struct{}{}
+		cfg.HostConfig.PortBindings = map[string][]PortBinding{}
+		for _, p := range s.Ports {
+			cfg.ExposedPorts[p.key()] = struct{}{}
+			if p.HostPort == 0 {
+				continue
+			}
+			cfg.HostConfig.PortBindings[p.key()] = append(cfg.HostConfig.PortBindings[p.key()],
+				PortBinding{HostIP: p.HostIP, HostPort: strconv.Itoa(p.HostPort)})
+		}
+	}
+	if s.Network != "" {
+		cfg.HostConfig.NetworkMode = s.Network
+		cfg.NetworkingConfig.EndpointsConfig = map[string]EndpointConfig{
+			s.Network: {Aliases: s.Aliases},
+		}
+	}
+	return cfg
+}
+
+// runArgs returns the `run` command line of the spec.
+func (s containerSpec) runArgs() []string {
+	args := []string{"run"}
+	if s.AutoRemove {
+		args = append(args, "--rm")
+	}
+	if s.Tty {
+		args = append(args, "-it")
+	}
+	if s.Name != "" {
+		args = append(args, "--name", s.Name)
+	}
+	if s.Platform != "" {
+		args = append(args, "--platform", s.Platform)
+	}
+	if s.Network != "" {
+		args = append(args, "--network", s.Network)
+	}
+	for _, a := range s.Aliases {
+		args = append(args, "--network-alias", a)
+	}
+	if s.Restart != "" {
+		args = append(args, "--restart", s.Restart)
+	}
+	for _, p := range s.Ports {
+		host := strconv.Itoa(p.HostPort)
+		if p.HostIP != "" {
+			host = p.HostIP + ":" + host
+		}
+		args = append(args, "-p", host+":"+p.key())
+	}
+	for _, b := range s.Binds {
+		args = append(args, "-v", b)
+	}
+	for _, h := range s.ExtraHosts {
+		args = append(args, "--add-host", h)
+	}
+	for _, c := range s.CapAdd {
+		args = append(args, "--cap-add", c)
+	}
+	for _, o := range s.SecurityOpt {
+		args = append(args, "--security-opt", o)
+	}
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--label", k+"="+s.Labels[k])
+	}
+	for _, e := range s.Env {
+		args = append(args, "-e", e)
+	}
+	args = append(args, s.Image)
+	return append(args, s.Cmd...)
+}
+
+// runContainer runs spec in the foreground until it exits or Ctrl-C stops
+// it. Through the engine API the container has no TTY or stdin, its output
+// is streamed from the logs; the CLI fallback runs it interactively.
+func runContainer(spec containerSpec) error {
+	api := engineAPI()
+	if api == nil {
+		spec.Tty = true
+		cmd := containerEngine().Command(spec.runArgs()...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Stdin = os.Stdin
+		return runCmd(cmd)
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	bg := context.Background()
+
+	existing, err := api.ContainerInspect(bg, spec.Name)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if existing != nil {
+		if existing.State.Running {
+			return fmt.Errorf("container %s is already running", spec.Name)
+		}
+		if err := api.ContainerRemove(bg, spec.Name); err != nil {
+			return err
+		}
+	}
+
+	spec.Tty = false
+	if _, err := api.ContainerCreate(bg, spec.Name, spec.Platform, spec.createConfig()); err != nil {
+		return err
+	}
+	wait, err := api.ContainerWait(bg, spec.Name, "next-exit")
+	if err != nil {
+		_ = api.ContainerRemove(bg, spec.Name)
+		return err
+	}
+	if err := api.ContainerStart(bg, spec.Name); err != nil {
+		_ = api.ContainerRemove(bg, spec.Name)
+		return err
+	}
+
+	logsDone := make(chan struct{})
+	go func() {
+		defer close(logsDone)
+		if err := api.ContainerLogs(bg, spec.Name, true, "all", os.Stdout, os.Stderr); err != nil {
+			debugf("logs of %s: %v", spec.Name, err)
+		}
+	}()
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := api.ContainerStop(bg, spec.Name, 10*time.Second); err != nil && !isNotFound(err) {
+				warnf("%v", err)
+			}
+		case <-exited:
+		}
+	}()
+
+	code, err := wait()
+	// The log stream ends with the container, let it print the last lines
+	select {
+	case <-logsDone:
+	case <-time.After(2 * time.Second):
+	}
+	if err != nil {
+		return err
+	}
+	if code != 0 && ctx.Err() == nil {
+		return fmt.Errorf("container %s exited with code %d", spec.Name, code)
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Commands the CLI can send to the dev container
+var controlCommands = []string{"rebuild", "pause", "resume", "restart"}
+
+// BuildEvent is posted by control.sh inside the dev container.
+type BuildEvent struct {
+	Type   string `json:"type"`
+	Output string `json:"output,omitempty"`
+}
+
+// ControlServer is the HTTP endpoint `polycode run` exposes to its container.
+// The container posts build events and long-polls for commands; other CLI
+// invocations queue commands through the same endpoint.
+type ControlServer struct {
+	token      string
+	listener   net.Listener
+	commands   chan string
+	mu         sync.Mutex
+	buildStart time.Time
+}
+
+type controlInfo struct {
+	Host  string `json:"host"`
+	Port  int    `json:"port"`
+	Token string `json:"token"`
+}
+
+func controlInfoPath(appName string) string {
+	dir := filepath.Join(getPolycodeDir(), "run")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		panic(fmt.Errorf("failed to create run directory: %w", err))
+	}
+	return filepath.Join(dir, appName+".json")
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// controlListenHost is the one address the container can reach the control
+// server on. On Linux host-gateway is the docker0 bridge, elsewhere (and
+// through an ssh tunnel) the engine forwards to the host's loopback.
+func controlListenHost() string {
+	if runtime.GOOS != "linux" || remoteHost() != nil {
+		return "127.0.0.1"
+	}
+	if iface, err := net.InterfaceByName("docker0"); err == nil {
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			if ip, ok := a.(*net.IPNet); ok && ip.IP.To4() != nil {
+				return ip.IP.String()
+			}
+		}
+	}
+	debugf("no docker0 bridge address, the control server only listens on 127.0.0.1")
+	return "127.0.0.1"
+}
+
+// startControlServer listens on the bridge (or loopback) address only, so
+// other hosts cannot send commands, and records the port and token for
+// `polycode app` in ~/.polycode/run/<app>.json.
+func startControlServer(appName string) (*ControlServer, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort(controlListenHost(), "0"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start control server: %w", err)
+	}
+
+	s := &ControlServer{
+		token:    token,
+		listener: l,
+		commands: make(chan string, 8),
+	}
+
+	info, _ := json.Marshal(controlInfo{Host: l.Addr().(*net.TCPAddr).IP.String(), Port: s.Port(), Token: token})
+	if err := os.WriteFile(controlInfoPath(appName), info, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to write control info: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", s.handleEvent)
+	mux.HandleFunc("/commands", s.handleCommands)
+	go http.Serve(l, mux)
+
+	return s, nil
+}
+
+func (s *ControlServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *ControlServer) Close(appName string) {
+	s.listener.Close()
+	_ = os.Remove(controlInfoPath(appName))
+}
+
+// apply points the container at the server.
+func (s *ControlServer) apply(spec *containerSpec) {
+	spec.ExtraHosts = append(spec.ExtraHosts, "host.docker.internal:host-gateway")
+	spec.Env = append(spec.Env,
+		fmt.Sprintf("CONTROL_URL=http://host.docker.internal:%d", s.Port()),
+		"CONTROL_TOKEN="+s.token,
+	)
+}
+
+func (s *ControlServer) authorized(r *http.Request) bool {
+	return r.Header.Get("Authorization") == "Bearer "+s.token
+}
+
+func (s *ControlServer) handleEvent(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) || r.Method != http.MethodPost {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var ev BuildEvent
+	if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.printStatus(ev)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *ControlServer) printStatus(ev BuildEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch ev.Type {
+	case "build-start":
+		s.buildStart = time.Now()
+		fmt.Println("⏳ Building...")
+	case "build-success":
+		fmt.Printf("✅ Build succeeded%s\n", s.buildDuration())
+	case "build-failure":
+		fmt.Printf("❌ Build failed%s\n", s.buildDuration())
+		for _, line := range strings.Split(strings.TrimRight(ev.Output, "\n"), "\n") {
+			fmt.Println("   " + line)
+		}
+	case "app-start":
+		fmt.Println("▶️  App started")
+	case "paused":
+		fmt.Println("⏸️  Watching paused")
+	case "resumed":
+		fmt.Println("▶️  Watching resumed")
+	}
+}
+
+func (s *ControlServer) buildDuration() string {
+	if s.buildStart.IsZero() {
+		return ""
+	}
+	d := time.Since(s.buildStart).Round(100 * time.Millisecond)
+	s.buildStart = time.Time{}
+	return " in " + d.String()
+}
+
+// handleCommands queues a command on POST and hands it out on GET, waiting up
+// to ?wait seconds for one to arrive.
+func (s *ControlServer) handleCommands(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		body, _ := io.ReadAll(r.Body)
+		select {
+		case s.commands <- strings.TrimSpace(string(body)):
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.Error(w, "too many pending commands", http.StatusServiceUnavailable)
+		}
+
+	case http.MethodGet:
+		wait := 30 * time.Second
+		if d, err := time.ParseDuration(r.URL.Query().Get("wait") + "s"); err == nil {
+			wait = d
+		}
+		select {
+		case cmd := <-s.commands:
+			fmt.Fprint(w, cmd)
+		case <-time.After(wait):
+			w.WriteHeader(http.StatusNoContent)
+		case <-r.Context().Done():
+		}
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// sendControlCommand queues cmd for the `polycode run` of appName.
+func sendControlCommand(appName, cmd string) error {
+	valid := false
+	for _, c := range controlCommands {
+		valid = valid || c == cmd
+	}
+	if !valid {
+		return fmt.Errorf("unknown command '%s'", cmd)
+	}
+
+	data, err := os.ReadFile(controlInfoPath(appName))
+	if err != nil {
+		return fmt.Errorf("app %s is not running", appName)
+	}
+	var info controlInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return fmt.Errorf("failed to parse control info: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf("http://%s/commands", net.JoinHostPort(firstNonEmpty(info.Host, "127.0.0.1"), strconv.Itoa(info.Port))), strings.NewReader(cmd))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+info.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach app %s: %w", appName, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("command rejected: %s", strings.TrimSpace(string(msg)))
+	}
+
+	fmt.Printf("✅ Sent %s to %s.\n", cmd, appName)
+	return nil
+}
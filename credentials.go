@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// legacyCredentials are the static MinIO credentials older installations
+// were set up with.
+var legacyCredentials = Credentials{AccessKey: "minioadmin", SecretKey: "minioadmin"}
+
+// Credentials for the local MinIO, also used as the AWS keys of every client
+// talking to the local platform.
+type Credentials struct {
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+}
+
+func credentialsPath() string {
+	return filepath.Join(getPolycodeDir(), "credentials.json")
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate credentials: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hasMinioData reports whether MinIO was already initialised, in which case
+// the credentials it was started with must be kept.
+func hasMinioData() bool {
+	entries, err := os.ReadDir(filepath.Join(getPolycodeDir(), "data", "minio"))
+	return err == nil && len(entries) > 0
+}
+
+// loadCredentials returns the credentials of this installation, generating
+// them on first use. Installations that already hold MinIO data keep the
+// legacy credentials.
+func loadCredentials() (*Credentials, error) {
+	path := credentialsPath()
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		creds := &Credentials{}
+		if err := json.Unmarshal(data, creds); err != nil {
+			return nil, fmt.Errorf("failed to parse credentials: %w", err)
+		}
+		// Tighten permissions left over from manual edits
+		if err := os.Chmod(path, 0600); err != nil {
+			return nil, fmt.Errorf("failed to restrict credentials file: %w", err)
+		}
+		return creds, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	creds := legacyCredentials
+	if !hasMinioData() {
+		if creds.AccessKey, err = randomString(12); err != nil {
+			return nil, err
+		}
+		if creds.SecretKey, err = randomString(30); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err = json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode credentials: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write credentials: %w", err)
+	}
+	return &creds, nil
+}
+
+// credentialsEnv returns the AWS variables for clients of the local platform.
+func credentialsEnv(creds *Credentials) []string {
+	return []string{
+		"AWS_ACCESS_KEY_ID=" + creds.AccessKey,
+		"AWS_SECRET_ACCESS_KEY=" + creds.SecretKey,
+	}
+}
+
+// minioEnv returns the root credentials of the local MinIO.
+func minioEnv(creds *Credentials) []string {
+	return []string{
+		"MINIO_ROOT_USER=" + creds.AccessKey,
+		"MINIO_ROOT_PASSWORD=" + creds.SecretKey,
+	}
+}
+
+// platformEnv returns the settings docker-compose-platform.yml is
+// interpolated with, apart from credentials and JetStream.
+func platformEnv(cfg *Config) []string {
+	bind := "0.0.0.0"
+	if cfg.Platform.LocalhostOnly {
+		bind = "127.0.0.1"
+	}
+
+	env := []string{
+		"POLYCODE_BIND_ADDRESS=" + bind,
+		"MINIO_CORS_ALLOW_ORIGIN=" + firstNonEmpty(cfg.Platform.CORSOrigins, "*"),
+		"POLYCODE_FILES_EVENTS_SUBJECT=" + filesEventsSubject(cfg),
+	}
+	return append(env, cfg.Ports.withDefaults().composeEnv()...)
+}
+
+func showCredentials() error {
+	creds, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Access key:", creds.AccessKey)
+	fmt.Println("Secret key:", creds.SecretKey)
+	fmt.Println("Console:   ", platformPorts().consoleURL())
+	return nil
+}
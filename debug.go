@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// debugGCFlags turn off optimizations and inlining so breakpoints and locals
+// work under Delve.
+const debugGCFlags = `-gcflags="all=-N -l"`
+
+// debugEnv returns the container settings that swap the app for Delve and
+// build it without optimizations.
+func debugEnv(rt Runtime, opts RunOptions) ([]string, error) {
+	if rt.Name != "go" {
+		return nil, fmt.Errorf("--delve is only supported for Go apps, %s apps listen on port %d already", rt.Name, rt.DebugPort)
+	}
+
+	env := []string{"WATCH_RUN=/tmp/debug-run.sh"}
+	if strings.Contains(rt.Build, "go build") {
+		env = append(env, "WATCH_BUILD="+strings.ReplaceAll(rt.Build, "go build", "go build "+debugGCFlags))
+	} else {
+		fmt.Printf("⚠️  The build command does not run `go build`, add %s to it for reliable breakpoints.\n", debugGCFlags)
+	}
+	if opts.WaitForDebugger {
+		env = append(env, "DEBUG_WAIT=true")
+	}
+	return env, nil
+}
+
+// applyDebug grants the container what Delve needs to trace the app.
+func applyDebug(spec *containerSpec) {
+	spec.CapAdd = append(spec.CapAdd, "SYS_PTRACE")
+	spec.SecurityOpt = append(spec.SecurityOpt, "seccomp=unconfined")
+}
+
+func debugConfigName(appName string) string {
+	return "polycode: " + appName
+}
+
+// writeVSCodeLaunch adds or replaces the attach configuration for appName in
+// <projectRoot>/.vscode/launch.json, keeping any other configurations.
+func writeVSCodeLaunch(projectRoot, appName string, port int) error {
+	dir := filepath.Join(projectRoot, ".vscode")
+	path := filepath.Join(dir, "launch.json")
+
+	launch := map[string]interface{}{
+		"version":        "0.2.0",
+		"configurations": []interface{}{},
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &launch); err != nil {
+			return fmt.Errorf("cannot update %s (comments are not supported): %w", path, err)
+		}
+	}
+
+	name := debugConfigName(appName)
+	entry := map[string]interface{}{
+		"name":    name,
+		"type":    "go",
+		"request": "attach",
+		"mode":    "remote",
+		"host":    "127.0.0.1",
+		"port":    port,
+		"substitutePath": []map[string]string{
+			{"from": projectRoot, "to": "/project"},
+		},
+	}
+
+	existing, _ := launch["configurations"].([]interface{})
+	configs := []interface{}{entry}
+	for _, c := range existing {
+		if m, ok := c.(map[string]interface{}); ok && m["name"] == name {
+			continue
+		}
+		configs = append(configs, c)
+	}
+	launch["configurations"] = configs
+
+	data, err := json.MarshalIndent(launch, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode launch.json: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+const golandRunConfig = `<component name="ProjectRunConfigurationManager">
+  <configuration default="false" name="%s" type="GoRemoteDebugConfigurationType" factoryName="Go Remote">
+    <option name="disconnectOption" value="LEAVE" />
+    <option name="host" value="localhost" />
+    <option name="port" value="%d" />
+    <PathMappingSettings>
+      <option name="pathMappings">
+        <list>
+          <mapping local-root="%s" remote-root="/project" />
+        </list>
+      </option>
+    </PathMappingSettings>
+    <method v="2" />
+  </configuration>
+</component>
+`
+
+// writeGoLandRunConfig writes a shared "Go Remote" run configuration.
+func writeGoLandRunConfig(projectRoot, appName string, port int) error {
+	dir := filepath.Join(projectRoot, ".idea", "runConfigurations")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	file := "polycode_" + strings.NewReplacer("-", "_", ".", "_").Replace(appName) + ".xml"
+	path := filepath.Join(dir, file)
+	content := fmt.Sprintf(golandRunConfig, html.EscapeString(debugConfigName(appName)), port, html.EscapeString(projectRoot))
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func writeIDEConfigs(projectRoot, appName string, port int) {
+	if err := writeVSCodeLaunch(projectRoot, appName, port); err != nil {
+		fmt.Println("⚠️ ", err)
+	}
+	if err := writeGoLandRunConfig(projectRoot, appName, port); err != nil {
+		fmt.Println("⚠️ ", err)
+	}
+	fmt.Printf("🐞 Debugger on localhost:%d, attach with \"%s\" in VS Code or GoLand.\n", port, debugConfigName(appName))
+}
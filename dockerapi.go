@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Compose labels on the containers of a project.
+const (
+	labelComposeProject = "com.docker.compose.project"
+	labelComposeService = "com.docker.compose.service"
+)
+
+// APIError is an error response of the Engine API.
+type APIError struct {
+	Op         string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s (HTTP %d)", e.Op, e.Message, e.StatusCode)
+}
+
+func isNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// APIClient talks to the Docker Engine API (or Podman's compatible API) over
+// its unix socket. Besides inspecting, listing, logs and events it creates
+// the compose stacks and the app container. Image builds still go through
+// buildx, the plain API has no named build contexts or cache export.
+type APIClient struct {
+	socket string
+	http   *http.Client
+}
+
+func newAPIClient(socket string) *APIClient {
+	return &APIClient{
+		socket: socket,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+var (
+	sharedAPI     *APIClient
+	sharedAPIOnce sync.Once
+)
+
+// engineAPI returns a client for the API of the selected engine, or nil when
+// the engine has no reachable socket; callers then fall back to the CLI.
+func engineAPI() *APIClient {
+	sharedAPIOnce.Do(func() {
+		socket := containerEngine().Socket()
+		if socket == "" {
+			return
+		}
+		if _, err := os.Stat(socket); err != nil {
+			return
+		}
+
+		client := newAPIClient(socket)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if client.Ping(ctx) == nil {
+			sharedAPI = client
+		}
+	})
+	return sharedAPI
+}
+
+// unixSocketFromHost returns the socket path of a unix:// host URL.
+func unixSocketFromHost(host string) string {
+	if strings.HasPrefix(host, "unix://") {
+		return strings.TrimPrefix(host, "unix://")
+	}
+	return ""
+}
+
+func (c *APIClient) do(ctx context.Context, op, method, path string, query url.Values) (*http.Response, error) {
+	return c.send(ctx, op, method, path, query, nil, nil)
+}
+
+// send is do with a JSON body and extra headers.
+func (c *APIClient) send(ctx context.Context, op, method, path string, query url.Values, body interface{}, header http.Header) (*http.Response, error) {
+	u := "http://engine" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to encode request: %w", op, err)
+		}
+		payload = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, payload)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to reach %s: %w", op, c.socket, err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var body struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &body) != nil || body.Message == "" {
+			body.Message = strings.TrimSpace(string(data))
+		}
+		return nil, &APIError{Op: op, StatusCode: resp.StatusCode, Message: body.Message}
+	}
+	return resp, nil
+}
+
+func (c *APIClient) getJSON(ctx context.Context, op, path string, query url.Values, v interface{}) error {
+	resp, err := c.do(ctx, op, http.MethodGet, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s: failed to parse response: %w", op, err)
+	}
+	return nil
+}
+
+// filtersQuery encodes filters the way the API expects them.
+func filtersQuery(filters map[string][]string) url.Values {
+	q := url.Values{}
+	if len(filters) > 0 {
+		data, _ := json.Marshal(filters)
+		q.Set("filters", string(data))
+	}
+	return q
+}
+
+func (c *APIClient) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, "ping", http.MethodGet, "/_ping", nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// ContainerSummary is one entry of the container list.
+type ContainerSummary struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Status string            `json:"Status"`
+	Labels map[string]string `json:"Labels"`
+	Ports  []struct {
+		IP          string `json:"IP"`
+		PrivatePort int    `json:"PrivatePort"`
+		PublicPort  int    `json:"PublicPort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+}
+
+// Name returns the container name without the leading slash.
+func (s ContainerSummary) Name() string {
+	if len(s.Names) == 0 {
+		return s.ID
+	}
+	return strings.TrimPrefix(s.Names[0], "/")
+}
+
+func (c *APIClient) ContainerList(ctx context.Context, all bool, filters map[string][]string) ([]ContainerSummary, error) {
+	q := filtersQuery(filters)
+	if all {
+		q.Set("all", "1")
+	}
+	var out []ContainerSummary
+	err := c.getJSON(ctx, "list containers", "/containers/json", q, &out)
+	return out, err
+}
+
+// ProjectContainers lists every container of a compose project.
+func (c *APIClient) ProjectContainers(ctx context.Context, project string) ([]ContainerSummary, error) {
+	return c.ContainerList(ctx, true, map[string][]string{
+		"label": {labelComposeProject + "=" + project},
+	})
+}
+
+// ContainerDetails is the part of a container inspect the CLI uses.
+type ContainerDetails struct {
+	ID     string `json:"Id"`
+	Name   string `json:"Name"`
+	Config struct {
+		Tty    bool              `json:"Tty"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	State struct {
+		Status   string `json:"Status"`
+		Running  bool   `json:"Running"`
+		ExitCode int    `json:"ExitCode"`
+		Health   *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+}
+
+func (c *APIClient) ContainerInspect(ctx context.Context, name string) (*ContainerDetails, error) {
+	var out ContainerDetails
+	if err := c.getJSON(ctx, "inspect container "+name, "/containers/"+url.PathEscape(name)+"/json", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ContainerLogs copies the logs of a container to stdout and stderr,
+// following them when follow is set.
+func (c *APIClient) ContainerLogs(ctx context.Context, name string, follow bool, tail string, stdout, stderr io.Writer) error {
+	details, err := c.ContainerInspect(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	q := url.Values{"stdout": {"1"}, "stderr": {"1"}, "tail": {firstNonEmpty(tail, "all")}}
+	if follow {
+		q.Set("follow", "1")
+	}
+	resp, err := c.do(ctx, "logs of "+name, http.MethodGet, "/containers/"+url.PathEscape(name)+"/logs", q)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if details.Config.Tty {
+		_, err = io.Copy(stdout, resp.Body)
+	} else {
+		err = demuxLogs(resp.Body, stdout, stderr)
+	}
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("logs of %s: %w", name, err)
+	}
+	return nil
+}
+
+// demuxLogs splits the multiplexed stream of a container without a TTY:
+// every frame starts with the stream type and a big-endian payload size.
+func demuxLogs(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
+
+// EngineEvent is one message of the event stream.
+type EngineEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+// Events streams engine events matching filters until ctx is done. The
+// returned channel is closed when the stream ends; the error channel then
+// holds the reason, if any.
+func (c *APIClient) Events(ctx context.Context, filters map[string][]string) (<-chan EngineEvent, <-chan error) {
+	events := make(chan EngineEvent)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(events)
+
+		resp, err := c.do(ctx, "events", http.MethodGet, "/events", filtersQuery(filters))
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer resp.Body.Close()
+
+		dec := json.NewDecoder(bufio.NewReader(resp.Body))
+		for {
+			var ev EngineEvent
+			if err := dec.Decode(&ev); err != nil {
+				if ctx.Err() == nil && err != io.EOF {
+					errs <- fmt.Errorf("events: %w", err)
+				}
+				return
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, errs
+}
+
+// WaitRunning blocks until the named container is running. It subscribes to
+// start events before looking at the container, so a start in between is
+// not missed.
+func (c *APIClient) WaitRunning(ctx context.Context, name string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, errs := c.Events(ctx, map[string][]string{
+		"type":      {"container"},
+		"event":     {"start"},
+		"container": {name},
+	})
+
+	details, err := c.ContainerInspect(ctx, name)
+	if err == nil && details.State.Running {
+		return nil
+	}
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			return nil
+		}
+		if err := <-errs; err != nil {
+			return err
+		}
+		return ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ContainerStop stops the named container, killing it after timeout. A
+// container that is already stopped is not an error.
+func (c *APIClient) ContainerStop(ctx context.Context, name string, timeout time.Duration) error {
+	q := url.Values{"t": {fmt.Sprint(int(timeout.Seconds()))}}
+	resp, err := c.do(ctx, "stop container "+name, http.MethodPost, "/containers/"+url.PathEscape(name)+"/stop", q)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ContainerConfig is the body of a container create request.
+type ContainerConfig struct {
+	Image            string              `json:"Image"`
+	Cmd              []string            `json:"Cmd,omitempty"`
+	Env              []string            `json:"Env,omitempty"`
+	Labels           map[string]string   `json:"Labels,omitempty"`
+	Tty              bool                `json:"Tty"`
+	ExposedPorts     map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig       HostConfig          `json:"HostConfig"`
+	NetworkingConfig struct {
+		EndpointsConfig map[string]EndpointConfig `json:"EndpointsConfig,omitempty"`
+	} `json:"NetworkingConfig"`
+}
+
+type HostConfig struct {
+	Binds         []string                 `json:"Binds,omitempty"`
+	PortBindings  map[string][]PortBinding `json:"PortBindings,omitempty"`
+	ExtraHosts    []string                 `json:"ExtraHosts,omitempty"`
+	CapAdd        []string                 `json:"CapAdd,omitempty"`
+	SecurityOpt   []string                 `json:"SecurityOpt,omitempty"`
+	NetworkMode   string                   `json:"NetworkMode,omitempty"`
+	AutoRemove    bool                     `json:"AutoRemove"`
+	RestartPolicy struct {
+		Name string `json:"Name,omitempty"`
+	} `json:"RestartPolicy"`
+}
+
+type PortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+type EndpointConfig struct {
+	Aliases []string `json:"Aliases,omitempty"`
+}
+
+// ContainerCreate creates a container and returns its ID.
+func (c *APIClient) ContainerCreate(ctx context.Context, name, platform string, cfg *ContainerConfig) (string, error) {
+	q := url.Values{"name": {name}}
+	if platform != "" {
+		q.Set("platform", platform)
+	}
+	resp, err := c.send(ctx, "create container "+name, http.MethodPost, "/containers/create", q, cfg, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		ID       string   `json:"Id"`
+		Warnings []string `json:"Warnings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("create container %s: failed to parse response: %w", name, err)
+	}
+	for _, w := range out.Warnings {
+		warnf("%s: %s", name, w)
+	}
+	return out.ID, nil
+}
+
+// ContainerStart starts a created or stopped container.
+func (c *APIClient) ContainerStart(ctx context.Context, name string) error {
+	resp, err := c.do(ctx, "start container "+name, http.MethodPost, "/containers/"+url.PathEscape(name)+"/start", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ContainerRemove removes a container, killing it first when it runs. A
+// container that does not exist is not an error.
+func (c *APIClient) ContainerRemove(ctx context.Context, name string) error {
+	resp, err := c.do(ctx, "remove container "+name, http.MethodDelete, "/containers/"+url.PathEscape(name), url.Values{"force": {"1"}})
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ContainerWait registers a wait for condition ("next-exit", "removed")
+// and returns once the engine accepted it, so a container started after
+// the call cannot exit unnoticed. The returned function blocks until the
+// condition is met and yields the exit code.
+func (c *APIClient) ContainerWait(ctx context.Context, name, condition string) (func() (int, error), error) {
+	op := "wait for container " + name
+	resp, err := c.do(ctx, op, http.MethodPost, "/containers/"+url.PathEscape(name)+"/wait", url.Values{"condition": {condition}})
+	if err != nil {
+		return nil, err
+	}
+	return func() (int, error) {
+		defer resp.Body.Close()
+		var out struct {
+			StatusCode int `json:"StatusCode"`
+			Error      *struct {
+				Message string `json:"Message"`
+			} `json:"Error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		if out.Error != nil && out.Error.Message != "" {
+			return out.StatusCode, fmt.Errorf("%s: %s", op, out.Error.Message)
+		}
+		return out.StatusCode, nil
+	}, nil
+}
+
+// ImagePull pulls ref for platform (the engine's own when empty). auth is
+// the X-Registry-Auth value, empty for public images. Failures after the
+// pull started are reported inside the progress stream.
+func (c *APIClient) ImagePull(ctx context.Context, ref, platform, auth string) error {
+	repo, tag := splitImageRef(ref)
+	q := url.Values{"fromImage": {repo}}
+	if tag != "" {
+		q.Set("tag", tag)
+	}
+	if platform != "" {
+		q.Set("platform", platform)
+	}
+	header := http.Header{}
+	if auth != "" {
+		header.Set("X-Registry-Auth", auth)
+	}
+
+	op := "pull " + ref
+	resp, err := c.send(ctx, op, http.MethodPost, "/images/create", q, nil, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if msg.Error != "" {
+			return &APIError{Op: op, StatusCode: resp.StatusCode, Message: msg.Error}
+		}
+	}
+}
+
+// splitImageRef splits ref into the repository and tag the pull endpoint
+// takes; a digest reference is passed whole.
+func splitImageRef(ref string) (string, string) {
+	if strings.Contains(ref, "@") {
+		return ref, ""
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
+
+// NetworkCreate creates a bridge network.
+func (c *APIClient) NetworkCreate(ctx context.Context, name string, labels map[string]string) error {
+	body := map[string]interface{}{"Name": name, "Driver": "bridge", "Labels": labels}
+	resp, err := c.send(ctx, "create network "+name, http.MethodPost, "/networks/create", nil, body, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// NetworkRemove removes a network. A network that does not exist is not an
+// error.
+func (c *APIClient) NetworkRemove(ctx context.Context, name string) error {
+	resp, err := c.do(ctx, "remove network "+name, http.MethodDelete, "/networks/"+url.PathEscape(name), nil)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// NetworkExists reports whether the named network exists.
+func (c *APIClient) NetworkExists(ctx context.Context, name string) (bool, error) {
+	var out struct {
+		ID string `json:"Id"`
+	}
+	err := c.getJSON(ctx, "inspect network "+name, "/networks/"+url.PathEscape(name), nil, &out)
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ImageDetails is the part of an image inspect the CLI uses.
+type ImageDetails struct {
+	ID     string `json:"Id"`
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+func (c *APIClient) ImageInspect(ctx context.Context, ref string) (*ImageDetails, error) {
+	var out ImageDetails
+	if err := c.getJSON(ctx, "inspect image "+ref, "/images/"+ref+"/json", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// prefixWriter prefixes every line written through it, for interleaving
+// the logs of several containers.
+type prefixWriter struct {
+	mu      *sync.Mutex
+	w       io.Writer
+	prefix  string
+	midLine bool
+}
+
+// logMu serialises the writes of all prefixWriters.
+var logMu sync.Mutex
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{mu: &logMu, w: w, prefix: prefix}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var out []byte
+	for _, c := range b {
+		if !p.midLine {
+			out = append(out, p.prefix...)
+			p.midLine = true
+		}
+		out = append(out, c)
+		if c == '\n' {
+			p.midLine = false
+		}
+	}
+	if _, err := p.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// podmanSocket returns the rootless socket when it exists, else the rootful one.
+func podmanSocket() string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		socket := filepath.Join(runtimeDir, "podman", "podman.sock")
+		if _, err := os.Stat(socket); err == nil {
+			return socket
+		}
+	}
+	return "/run/podman/podman.sock"
+}
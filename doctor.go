@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// minFreeDisk is the space below which images and platform data are likely
+// to run out of room.
+const minFreeDisk = 5 << 30
+
+type checkStatus string
+
+const (
+	checkOK   checkStatus = "ok"
+	checkWarn checkStatus = "warn"
+	checkFail checkStatus = "fail"
+)
+
+// CheckResult is the outcome of one doctor check.
+type CheckResult struct {
+	Name   string      `json:"name"`
+	Status checkStatus `json:"status"`
+	Detail string      `json:"detail"`
+	Fix    string      `json:"fix,omitempty"`
+}
+
+func okResult(name, detail string) CheckResult {
+	return CheckResult{Name: name, Status: checkOK, Detail: detail}
+}
+
+func warnResult(name, detail, fix string) CheckResult {
+	return CheckResult{Name: name, Status: checkWarn, Detail: detail, Fix: fix}
+}
+
+func failResult(name, detail, fix string) CheckResult {
+	return CheckResult{Name: name, Status: checkFail, Detail: detail, Fix: fix}
+}
+
+func checkEngine() []CheckResult {
+	engine := containerEngine()
+	if err := engine.Available(); err != nil {
+		return []CheckResult{failResult("engine", err.Error(),
+			fmt.Sprintf("Start %s, or pick another engine with `polycode config set engine <docker|podman|nerdctl>`.", engine.Name()))}
+	}
+
+	results := []CheckResult{okResult("engine", engine.Name()+" is running")}
+	caps := engine.Capabilities()
+
+	if caps.Compose == "" {
+		results = append(results, failResult("compose", "no compose v2 implementation found",
+			"Install the docker compose plugin (or podman-compose / nerdctl compose)."))
+	} else {
+		results = append(results, okResult("compose", caps.Compose))
+	}
+
+	if !caps.BuildContexts {
+		results = append(results, failResult("buildx", "builds with named build contexts are not supported",
+			"Install the docker buildx plugin: https://docs.docker.com/go/buildx/"))
+	} else {
+		results = append(results, okResult("buildx", "build contexts supported"))
+	}
+	return results
+}
+
+func checkPorts() []CheckResult {
+	ours := platformPublishedPorts()
+	ports := platformPorts()
+
+	uses := append(ports.uses(), portUse{orDefault(ports.Debug, 2345), "Delve", "ports.debug"})
+	var results []CheckResult
+	for _, p := range uses {
+		name := fmt.Sprintf("port %d", p.Port)
+		switch {
+		case ours[p.Port]:
+			results = append(results, okResult(name, "in use by the polycode platform"))
+		case portInUse(p.Port):
+			results = append(results, failResult(name, fmt.Sprintf("in use by another process, %s cannot bind it", p.Service),
+				fmt.Sprintf("Stop the process listening on %d (`lsof -i :%d`), or remap it with `polycode config set %s <port>`.", p.Port, p.Port, p.Key)))
+		default:
+			results = append(results, okResult(name, "free"))
+		}
+	}
+	return results
+}
+
+func checkAWS() []CheckResult {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	region := "us-east-1"
+	if profile, err := activeProfile(); err == nil {
+		region = profile.Registry.Region
+	}
+
+	cfg, err := loadAWSConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return []CheckResult{failResult("aws credentials", err.Error(), "Check ~/.aws/config and the AWS_* environment variables.")}
+	}
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return []CheckResult{failResult("aws credentials", "no credentials found: "+err.Error(),
+			"Run `aws configure` or `aws sso login`, or set AWS_PROFILE.")}
+	}
+	results := []CheckResult{okResult("aws credentials", "resolved from "+creds.Source)}
+
+	if _, err := ecr.NewFromConfig(cfg).GetAuthorizationToken(ctx, nil); err != nil {
+		results = append(results, failResult("aws ecr", err.Error(),
+			"Ask for ecr:GetAuthorizationToken on the registry accounts, or check the profile's registry region."))
+	} else {
+		results = append(results, okResult("aws ecr", "authorization token issued in "+region))
+	}
+
+	_, err = s3.NewFromConfig(cfg).HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String("buildspecs.polycode.app"),
+		Key:    aws.String("polycode/engine/latest.checksum"),
+	})
+	if err != nil {
+		results = append(results, failResult("aws s3", "cannot read the sidecar from buildspecs.polycode.app: "+err.Error(),
+			"Ask for s3:GetObject on buildspecs.polycode.app/polycode/engine/*."))
+	} else {
+		results = append(results, okResult("aws s3", "sidecar readable"))
+	}
+	return results
+}
+
+func checkDisk() CheckResult {
+	dir := getPolycodeDir()
+	free, err := diskFree(dir)
+	if err != nil {
+		return warnResult("disk space", "cannot determine free space: "+err.Error(), "")
+	}
+
+	detail := fmt.Sprintf("%.1f GiB free in %s", float64(free)/(1<<30), dir)
+	if free < minFreeDisk {
+		return warnResult("disk space", detail,
+			"Free up space, e.g. `docker system prune` or `polycode platform clean` (deletes local data).")
+	}
+	return okResult("disk space", detail)
+}
+
+func checkSidecar() CheckResult {
+	path := filepath.Join(getPolycodeDir(), "runtime", "sidecar")
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return failResult("sidecar", path+" is missing", "Run `polycode platform start` to download it.")
+	}
+	if err != nil {
+		return failResult("sidecar", err.Error(), "")
+	}
+	if info.Mode()&0111 == 0 {
+		return failResult("sidecar", path+" is not executable", "Run `chmod +x "+path+"`.")
+	}
+	return okResult("sidecar", path)
+}
+
+func checkNetwork() CheckResult {
+	var exists bool
+	if api := engineAPI(); api != nil {
+		var err error
+		if exists, err = api.NetworkExists(context.Background(), "polycode-dev"); err != nil {
+			return failResult("network", err.Error(), "")
+		}
+	} else {
+		exists = runCmd(containerEngine().Command("network", "inspect", "polycode-dev")) == nil
+	}
+
+	if !exists {
+		return failResult("network", "polycode-dev does not exist", "Run `polycode platform start`.")
+	}
+	return okResult("network", "polycode-dev exists")
+}
+
+func checkGit() CheckResult {
+	out, err := cmdOutput(exec.Command("git", "--version"))
+	if err != nil {
+		return warnResult("git", "git is not installed", "Install git, or mark project roots with go.work / polycode.yaml or --project-root.")
+	}
+	return okResult("git", strings.TrimSpace(string(out)))
+}
+
+// runDoctor runs every check and prints the results, as JSON when asked.
+// It fails when any check failed.
+func runDoctor(asJSON bool) error {
+	var results []CheckResult
+	results = append(results, checkEngine()...)
+	results = append(results, checkPorts()...)
+	results = append(results, checkAWS()...)
+	results = append(results, checkDisk(), checkSidecar(), checkNetwork(), checkGit())
+
+	failed := 0
+	for _, r := range results {
+		if r.Status == checkFail {
+			failed++
+		}
+	}
+
+	if asJSON {
+		if err := printJSON(results); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range results {
+			icon := "✅"
+			switch r.Status {
+			case checkWarn:
+				icon = "⚠️ "
+			case checkFail:
+				icon = "❌"
+			}
+			fmt.Printf("%s %-16s %s\n", icon, r.Name, r.Detail)
+			if r.Fix != "" {
+				fmt.Printf("   %-16s 👉 %s\n", "", r.Fix)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Capabilities lists the optional features of a container engine the CLI
+// makes use of.
+type Capabilities struct {
+	// Compose is the compose implementation found, empty when there is none.
+	Compose string
+	// BuildContexts supports named --build-context sources.
+	BuildContexts bool
+	// CacheExport can export and import a local build cache.
+	CacheExport bool
+	// ManifestInspect can read manifests from a registry without pulling.
+	ManifestInspect bool
+	// HostGateway resolves the "host-gateway" alias in --add-host.
+	HostGateway bool
+}
+
+// Engine is a container engine with a docker-compatible CLI.
+type Engine interface {
+	Name() string
+	// Available returns an error when the engine or its daemon is unreachable.
+	Available() error
+	Capabilities() Capabilities
+	// Command runs the engine CLI, e.g. Command("run", ...).
+	Command(args ...string) *exec.Cmd
+	// Compose runs the compose implementation of the engine.
+	Compose(args ...string) *exec.Cmd
+	// Build runs an image build that leaves the result in the local store.
+	Build(args ...string) *exec.Cmd
+	// Arch returns the architecture containers run on natively.
+	Arch() (string, error)
+	// RawManifest returns the manifest or index of a registry image.
+	RawManifest(image string) ([]byte, error)
+	// ManifestDigest returns the registry digest of image.
+	ManifestDigest(image string) (string, error)
+	// Socket returns the unix socket of the engine's Docker-compatible API,
+	// empty when it has none.
+	Socket() string
+}
+
+// engines maps the backend names accepted by the engine config key to their
+// constructors; engineOrder is the auto-detection order.
+var engines = map[string]func() Engine{
+	"docker":  func() Engine { return &dockerEngine{cliEngine{bin: "docker"}} },
+	"podman":  func() Engine { return &podmanEngine{cliEngine{bin: "podman"}} },
+	"nerdctl": func() Engine { return &nerdctlEngine{cliEngine{bin: "nerdctl"}} },
+}
+
+var engineOrder = []string{"docker", "podman", "nerdctl"}
+
+var (
+	selectedEngine Engine
+	selectEngine   sync.Once
+)
+
+// containerEngine returns the engine set by POLYCODE_ENGINE or the engine
+// config key, or else the first one that is available. Docker is assumed
+// when none is, so commands fail with the familiar docker errors.
+func containerEngine() Engine {
+	selectEngine.Do(func() {
+		name := os.Getenv("POLYCODE_ENGINE")
+		if name == "" {
+			if cfg, err := loadConfig(); err == nil {
+				name = cfg.Engine
+			}
+		}
+		if newEngine, ok := engines[name]; ok {
+			selectedEngine = newEngine()
+			return
+		}
+		if name != "" {
+			fmt.Printf("⚠️  Unknown container engine '%s', detecting one instead.\n", name)
+		}
+
+		for _, n := range engineOrder {
+			if e := engines[n](); e.Available() == nil {
+				selectedEngine = e
+				return
+			}
+		}
+		selectedEngine = engines["docker"]()
+	})
+	return selectedEngine
+}
+
+// requireEngine fails with DockerUnavailable when the selected engine is not
+// installed or not running.
+func requireEngine() error {
+	if err := containerEngine().Available(); err != nil {
+		return newCLIError(ErrDockerUnavailable, err)
+	}
+	return nil
+}
+
+// cliEngine implements the parts the docker-compatible CLIs share.
+type cliEngine struct {
+	bin string
+}
+
+func (e *cliEngine) Name() string {
+	return e.bin
+}
+
+func (e *cliEngine) Command(args ...string) *exec.Cmd {
+	return exec.Command(e.bin, args...)
+}
+
+func (e *cliEngine) Available() error {
+	if _, err := exec.LookPath(e.bin); err != nil {
+		return fmt.Errorf("%s is not installed", e.bin)
+	}
+	if out, err := cmdCombinedOutput(e.Command("info")); err != nil {
+		return fmt.Errorf("%s is not running: %s", e.bin, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (e *cliEngine) succeeds(args ...string) bool {
+	return runCmd(e.Command(args...)) == nil
+}
+
+func (e *cliEngine) output(args ...string) (string, error) {
+	out, err := cmdOutput(e.Command(args...))
+	return strings.TrimSpace(string(out)), err
+}
+
+type dockerEngine struct {
+	cliEngine
+}
+
+func (e *dockerEngine) Capabilities() Capabilities {
+	caps := Capabilities{HostGateway: true}
+	if e.succeeds("compose", "version") {
+		caps.Compose = "docker compose"
+	}
+	if e.succeeds("buildx", "version") {
+		caps.BuildContexts = true
+		caps.CacheExport = true
+		caps.ManifestInspect = true
+	}
+	return caps
+}
+
+func (e *dockerEngine) Socket() string {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return unixSocketFromHost(host)
+	}
+	if _, err := os.Stat("/var/run/docker.sock"); err != nil {
+		// Docker Desktop without the system-wide symlink
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, ".docker", "run", "docker.sock")
+		}
+	}
+	return "/var/run/docker.sock"
+}
+
+func (e *dockerEngine) Compose(args ...string) *exec.Cmd {
+	return e.Command(append([]string{"compose"}, args...)...)
+}
+
+func (e *dockerEngine) Build(args ...string) *exec.Cmd {
+	cmd := e.Command(append([]string{"build", "--load"}, args...)...)
+	cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+	return cmd
+}
+
+func (e *dockerEngine) Arch() (string, error) {
+	return e.output("version", "--format", "{{.Server.Arch}}")
+}
+
+func (e *dockerEngine) RawManifest(image string) ([]byte, error) {
+	return cmdOutput(e.Command("buildx", "imagetools", "inspect", "--raw", image))
+}
+
+func (e *dockerEngine) ManifestDigest(image string) (string, error) {
+	return e.output("buildx", "imagetools", "inspect", "--format", "{{.Manifest.Digest}}", image)
+}
+
+type podmanEngine struct {
+	cliEngine
+}
+
+func (e *podmanEngine) Capabilities() Capabilities {
+	caps := Capabilities{
+		BuildContexts:   true,
+		ManifestInspect: true,
+		HostGateway:     true,
+	}
+	if e.succeeds("compose", "version") {
+		caps.Compose = "podman compose"
+	} else if _, err := exec.LookPath("podman-compose"); err == nil {
+		caps.Compose = "podman-compose"
+	}
+	return caps
+}
+
+func (e *podmanEngine) Socket() string {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return unixSocketFromHost(host)
+	}
+	return podmanSocket()
+}
+
+// Compose prefers the `podman compose` wrapper (which delegates to
+// docker-compose or podman-compose) and falls back to podman-compose.
+func (e *podmanEngine) Compose(args ...string) *exec.Cmd {
+	if e.succeeds("compose", "version") {
+		return e.Command(append([]string{"compose"}, args...)...)
+	}
+	return exec.Command("podman-compose", args...)
+}
+
+// Build needs no --load, podman builds straight into its image store.
+func (e *podmanEngine) Build(args ...string) *exec.Cmd {
+	return e.Command(append([]string{"build"}, args...)...)
+}
+
+func (e *podmanEngine) Arch() (string, error) {
+	return e.output("info", "--format", "{{.Host.Arch}}")
+}
+
+func (e *podmanEngine) RawManifest(image string) ([]byte, error) {
+	return cmdOutput(e.Command("manifest", "inspect", image))
+}
+
+// ManifestDigest hashes the manifest podman prints. Podman reformats it, so
+// the result differs from the registry digest but is stable for change
+// detection.
+func (e *podmanEngine) ManifestDigest(image string) (string, error) {
+	raw, err := e.RawManifest(image)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+type nerdctlEngine struct {
+	cliEngine
+}
+
+func (e *nerdctlEngine) Capabilities() Capabilities {
+	caps := Capabilities{
+		BuildContexts: true,
+		CacheExport:   true,
+		HostGateway:   true,
+	}
+	if e.succeeds("compose", "version") {
+		caps.Compose = "nerdctl compose"
+	}
+	return caps
+}
+
+// Socket is empty, containerd has no Docker-compatible API.
+func (e *nerdctlEngine) Socket() string {
+	return ""
+}
+
+func (e *nerdctlEngine) Compose(args ...string) *exec.Cmd {
+	return e.Command(append([]string{"compose"}, args...)...)
+}
+
+func (e *nerdctlEngine) Build(args ...string) *exec.Cmd {
+	return e.Command(append([]string{"build"}, args...)...)
+}
+
+func (e *nerdctlEngine) Arch() (string, error) {
+	return e.output("info", "--format", "{{.Architecture}}")
+}
+
+func (e *nerdctlEngine) RawManifest(image string) ([]byte, error) {
+	return nil, fmt.Errorf("nerdctl cannot inspect registry manifests")
+}
+
+func (e *nerdctlEngine) ManifestDigest(image string) (string, error) {
+	return "", fmt.Errorf("nerdctl cannot inspect registry manifests")
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// engineReport prints which engines are available and what each supports.
+func engineReport() error {
+	current := containerEngine().Name()
+
+	for _, name := range engineOrder {
+		e := engines[name]()
+		marker := "  "
+		if name == current {
+			marker = "👉"
+		}
+		if err := e.Available(); err != nil {
+			fmt.Printf("%s %-8s unavailable: %v\n", marker, name, err)
+			continue
+		}
+
+		caps := e.Capabilities()
+		fmt.Printf("%s %s\n", marker, name)
+		fmt.Println("   compose          :", firstNonEmpty(caps.Compose, "missing"))
+		fmt.Println("   build contexts   :", yesNo(caps.BuildContexts))
+		fmt.Println("   cache export     :", yesNo(caps.CacheExport))
+		fmt.Println("   manifest inspect :", yesNo(caps.ManifestInspect))
+		fmt.Println("   host gateway     :", yesNo(caps.HostGateway))
+	}
+	return nil
+}
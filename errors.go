@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrorKind classifies failures the CLI can explain, so wrappers and IDE
+// plugins can tell them apart by exit code or by the code of the --json
+// envelope.
+type ErrorKind struct {
+	Code     string
+	ExitCode int
+	// Hint is the default remediation shown with the error.
+	Hint string
+}
+
+// Exit code 1 stays for errors that are not classified.
+var (
+	ErrDockerUnavailable = ErrorKind{"DockerUnavailable", 10,
+		"Start the container engine, or pick another one with `polycode config set engine <docker|podman|nerdctl>`. `polycode doctor` shows what is missing."}
+	ErrRegistryAuthFailed = ErrorKind{"RegistryAuthFailed", 11,
+		"Check your AWS credentials (`aws sso login`, AWS_PROFILE) and that they allow ecr:GetAuthorizationToken for the profile's registry."}
+	ErrSidecarSyncFailed = ErrorKind{"SidecarSyncFailed", 12,
+		"Check your AWS credentials and that they can read buildspecs.polycode.app/polycode/engine/*."}
+	ErrPortInUse = ErrorKind{"PortInUse", 13,
+		"Stop the process holding the port, or remap it with `polycode config set ports.<name> <port>`."}
+	ErrNotAGitRepo = ErrorKind{"NotAGitRepo", 14,
+		"Run the command inside the project's git repository, add a polycode.yaml or go.work at the project root, or pass --project-root."}
+)
+
+// CLIError is an error of a known kind with a remediation hint.
+type CLIError struct {
+	Kind ErrorKind
+	Err  error
+	Hint string
+}
+
+func (e *CLIError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CLIError) Unwrap() error {
+	return e.Err
+}
+
+// newCLIError classifies err with the default hint of kind.
+func newCLIError(kind ErrorKind, err error) *CLIError {
+	return &CLIError{Kind: kind, Err: err, Hint: kind.Hint}
+}
+
+// withHint replaces the default hint with a more specific one.
+func (e *CLIError) withHint(hint string) *CLIError {
+	e.Hint = hint
+	return e
+}
+
+// asCLIError finds the classified error in the chain of err, if any.
+func asCLIError(err error) (*CLIError, bool) {
+	var cliErr *CLIError
+	ok := errors.As(err, &cliErr)
+	return cliErr, ok
+}
+
+func exitCode(err error) int {
+	if cliErr, ok := asCLIError(err); ok {
+		return cliErr.Kind.ExitCode
+	}
+	return 1
+}
+
+// errorEnvelope is what --json prints on stderr when a command fails.
+type errorEnvelope struct {
+	Error struct {
+		Code     string `json:"code"`
+		Message  string `json:"message"`
+		Hint     string `json:"hint,omitempty"`
+		ExitCode int    `json:"exitCode"`
+	} `json:"error"`
+}
+
+// jsonErrors is set by the global --json flag.
+var jsonErrors bool
+
+// printError reports a failed command on stderr.
+func printError(err error, asJSON bool) {
+	cliErr, classified := asCLIError(err)
+
+	if asJSON {
+		var env errorEnvelope
+		env.Error.Code = "Unknown"
+		env.Error.Message = err.Error()
+		env.Error.ExitCode = exitCode(err)
+		if classified {
+			env.Error.Code = cliErr.Kind.Code
+			env.Error.Hint = cliErr.Hint
+		}
+		enc := json.NewEncoder(os.Stderr)
+		enc.SetEscapeHTML(false)
+		enc.Encode(env)
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	if classified && cliErr.Hint != "" {
+		fmt.Fprintln(os.Stderr, "👉", cliErr.Hint)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// remotePrefix marks bucket paths in `polycode files cp`.
+const remotePrefix = "s3:"
+
+// FileStore works on the local files bucket, optionally scoped to the
+// prefix of one environment.
+type FileStore struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func newFileStore(ctx context.Context, envID string) (*FileStore, error) {
+	cfg, err := devAWSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &FileStore{client: devS3Client(cfg), bucket: filesBucket}
+	if envID != "" {
+		store.prefix = envID + "/"
+	}
+	return store, nil
+}
+
+func isRemote(p string) bool {
+	return strings.HasPrefix(p, remotePrefix)
+}
+
+// key maps a user path (with or without "s3:") to an object key.
+func (f *FileStore) key(p string) string {
+	p = strings.TrimPrefix(p, remotePrefix)
+	return f.prefix + strings.TrimPrefix(p, "/")
+}
+
+// display strips the environment prefix from a key.
+func (f *FileStore) display(key string) string {
+	return strings.TrimPrefix(key, f.prefix)
+}
+
+func (f *FileStore) list(ctx context.Context, prefix string, recursive bool, fn func(types.Object)) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(f.bucket),
+		Prefix: aws.String(prefix),
+	}
+	if !recursive {
+		input.Delimiter = aws.String("/")
+	}
+
+	var dirs []string
+	paginator := s3.NewListObjectsV2Paginator(f.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+		for _, p := range page.CommonPrefixes {
+			dirs = append(dirs, aws.ToString(p.Prefix))
+		}
+		for _, o := range page.Contents {
+			fn(o)
+		}
+	}
+	return dirs, nil
+}
+
+func (f *FileStore) Ls(ctx context.Context, p string, recursive bool) error {
+	prefix := f.key(p)
+	if prefix != f.prefix && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	dirs, err := f.list(ctx, prefix, recursive, func(o types.Object) {
+		fmt.Printf("%10d  %s  %s\n", aws.ToInt64(o.Size),
+			aws.ToTime(o.LastModified).Local().Format("2006-01-02 15:04"), f.display(aws.ToString(o.Key)))
+	})
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		fmt.Printf("%10s  %16s  %s\n", "DIR", "", f.display(d))
+	}
+	return nil
+}
+
+func (f *FileStore) Cat(ctx context.Context, p string) error {
+	out, err := f.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(f.bucket),
+		Key:    aws.String(f.key(p)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %w", p, err)
+	}
+	defer out.Body.Close()
+
+	_, err = io.Copy(os.Stdout, out.Body)
+	return err
+}
+
+func (f *FileStore) Rm(ctx context.Context, p string, recursive bool) error {
+	key := f.key(p)
+	if !recursive {
+		_, err := f.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(f.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete %s: %w", p, err)
+		}
+		fmt.Println("🗑️ ", f.display(key))
+		return nil
+	}
+
+	if key != f.prefix && !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	var keys []string
+	if _, err := f.list(ctx, key, true, func(o types.Object) {
+		keys = append(keys, aws.ToString(o.Key))
+	}); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if _, err := f.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(f.bucket),
+			Key:    aws.String(k),
+		}); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", f.display(k), err)
+		}
+		fmt.Println("🗑️ ", f.display(k))
+	}
+	return nil
+}
+
+func (f *FileStore) upload(ctx context.Context, local, key string) error {
+	file, err := os.Open(local)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", local, err)
+	}
+	defer file.Close()
+
+	_, err = manager.NewUploader(f.client).Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(f.bucket),
+		Key:    aws.String(key),
+		Body:   file,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", local, err)
+	}
+	fmt.Printf("⬆️  %s -> %s%s\n", local, remotePrefix, f.display(key))
+	return nil
+}
+
+func (f *FileStore) download(ctx context.Context, key, local string) error {
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(local), err)
+	}
+	file, err := os.Create(local)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", local, err)
+	}
+	defer file.Close()
+
+	_, err = manager.NewDownloader(f.client).Download(ctx, file, &s3.GetObjectInput{
+		Bucket: aws.String(f.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", f.display(key), err)
+	}
+	fmt.Printf("⬇️  %s%s -> %s\n", remotePrefix, f.display(key), local)
+	return nil
+}
+
+// Cp copies between the local disk and the bucket; exactly one of src and
+// dst must start with "s3:". A destination ending in "/" is treated as a
+// folder.
+func (f *FileStore) Cp(ctx context.Context, src, dst string, recursive bool) error {
+	switch {
+	case !isRemote(src) && isRemote(dst):
+		return f.cpUp(ctx, src, dst, recursive)
+	case isRemote(src) && !isRemote(dst):
+		return f.cpDown(ctx, src, dst, recursive)
+	}
+	return fmt.Errorf("exactly one of <src> and <dst> must be a bucket path (%s...)", remotePrefix)
+}
+
+func (f *FileStore) cpUp(ctx context.Context, src, dst string, recursive bool) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", src, err)
+	}
+
+	if !info.IsDir() {
+		key := f.key(dst)
+		if key == f.prefix || strings.HasSuffix(key, "/") {
+			key += filepath.Base(src)
+		}
+		return f.upload(ctx, src, key)
+	}
+
+	if !recursive {
+		return fmt.Errorf("%s is a folder, use -r to copy it", src)
+	}
+
+	base := strings.TrimSuffix(f.key(dst), "/")
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		key := path.Join(base, filepath.ToSlash(rel))
+		return f.upload(ctx, p, strings.TrimPrefix(key, "/"))
+	})
+}
+
+func (f *FileStore) cpDown(ctx context.Context, src, dst string, recursive bool) error {
+	key := f.key(src)
+
+	if !recursive {
+		local := dst
+		if info, err := os.Stat(dst); (err == nil && info.IsDir()) || strings.HasSuffix(dst, string(os.PathSeparator)) {
+			local = filepath.Join(dst, path.Base(key))
+		}
+		return f.download(ctx, key, local)
+	}
+
+	prefix := key
+	if prefix != f.prefix && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var keys []string
+	if _, err := f.list(ctx, prefix, true, func(o types.Object) {
+		keys = append(keys, aws.ToString(o.Key))
+	}); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("nothing found under %s", src)
+	}
+
+	for _, k := range keys {
+		// Keys are untrusted paths, "../" must not escape dst
+		local := filepath.Join(dst, filepath.FromSlash(strings.TrimPrefix(k, prefix)))
+		if !isWithin(dst, local) || local == filepath.Clean(dst) {
+			return fmt.Errorf("refusing to download %s to %s, it is outside %s", k, local, dst)
+		}
+		if err := f.download(ctx, k, local); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Presign prints a presigned GET (or PUT) URL for frontend testing.
+func (f *FileStore) Presign(ctx context.Context, p string, put bool, expires time.Duration) error {
+	presigner := s3.NewPresignClient(f.client, s3.WithPresignExpires(expires))
+
+	var url string
+	if put {
+		req, err := presigner.PresignPutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(f.bucket),
+			Key:    aws.String(f.key(p)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to presign PUT for %s: %w", p, err)
+		}
+		url = req.URL
+	} else {
+		req, err := presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(f.bucket),
+			Key:    aws.String(f.key(p)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to presign GET for %s: %w", p, err)
+		}
+		url = req.URL
+	}
+
+	fmt.Println(url)
+	return nil
+}
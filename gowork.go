@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// GoWorkspace is a go.work found between an app and its project root.
+type GoWorkspace struct {
+	// Dir holds go.work.
+	Dir string
+	// Modules are the absolute directories of its use directives.
+	Modules []string
+}
+
+// findGoWorkspace returns the go.work the go command would pick for appDir,
+// provided it lies inside the mounted project root; nil when there is none.
+func findGoWorkspace(projectRoot, appDir string) (*GoWorkspace, error) {
+	appDir, err := filepath.EvalSymlinks(appDir)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := findUp(appDir, projectRoot, workspaceFile)
+	if !ok || !isWithin(projectRoot, dir) {
+		return nil, nil
+	}
+
+	modules, err := parseGoWorkUses(filepath.Join(dir, workspaceFile))
+	if err != nil {
+		return nil, err
+	}
+	ws := &GoWorkspace{Dir: dir}
+	for _, m := range modules {
+		ws.Modules = append(ws.Modules, filepath.Clean(filepath.Join(dir, m)))
+	}
+	return ws, nil
+}
+
+// parseGoWorkUses returns the paths of the use directives of a go.work, in
+// both the single line and the block form.
+func parseGoWorkUses(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	defer f.Close()
+
+	var uses []string
+	inBlock := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case inBlock && line == ")":
+			inBlock = false
+		case inBlock && line != "":
+			uses = append(uses, strings.Trim(line, `"`))
+		case line == "use (" || line == "use(":
+			inBlock = true
+		case strings.HasPrefix(line, "use "):
+			uses = append(uses, strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "use ")), `"`))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return uses, nil
+}
+
+func (ws *GoWorkspace) uses(dir string) bool {
+	for _, m := range ws.Modules {
+		if m == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// applyGoWorkspace adapts the go runtime of the app in appDir to the
+// workspace: poly-watcher watches from the workspace directory so edits to
+// any module rebuild the app, and `go work sync` replaces `go mod tidy`,
+// which ignores the workspace and undoes its replacements. Settings the
+// manifest overrides are left alone.
+func applyGoWorkspace(rt Runtime, ws *GoWorkspace, projectRoot, appDir string) (Runtime, error) {
+	defaults := runtimes["go"]
+
+	appDir, err := filepath.EvalSymlinks(appDir)
+	if err != nil {
+		return rt, err
+	}
+	if !ws.uses(appDir) {
+		// The go command refuses to build a module the workspace leaves out
+		fmt.Printf("⚠️  %s is not listed in %s, building without the workspace.\n", appDir, filepath.Join(ws.Dir, workspaceFile))
+		rt.Env = withEnv(rt.Env, "GOWORK", "off")
+		return rt, nil
+	}
+
+	for _, m := range ws.Modules {
+		if !isWithin(projectRoot, m) {
+			fmt.Printf("⚠️  Workspace module %s is outside the project root %s and is not mounted.\n", m, projectRoot)
+		}
+	}
+
+	rel, err := filepath.Rel(projectRoot, ws.Dir)
+	if err != nil {
+		return rt, err
+	}
+	rt.WatchRoot = path.Join("/project", filepath.ToSlash(rel))
+
+	if rt.DepFile == defaults.DepFile {
+		rt.DepFile = workspaceFile
+	}
+	if rt.DepCommand == defaults.DepCommand {
+		rt.DepCommand = "go work sync && go mod download"
+	}
+	if strings.Join(rt.Include, ",") == strings.Join(defaults.Include, ",") {
+		rt.Include = append(append([]string{}, rt.Include...), workspaceFile)
+	}
+	return rt, nil
+}
+
+// withEnv returns a copy of env with key set to value.
+func withEnv(env map[string]string, key, value string) map[string]string {
+	out := map[string]string{key: value}
+	for k, v := range env {
+		if k != key {
+			out[k] = v
+		}
+	}
+	return out
+}
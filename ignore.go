@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// alwaysIgnored are never synced, matching the poly-watcher excludes.
+var alwaysIgnored = []string{".git", ".polycode"}
+
+type ignoreRule struct {
+	base     string // directory of the .gitignore, relative to the root
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	p := rel
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		p = strings.TrimPrefix(rel, r.base+"/")
+	}
+
+	if !r.anchored {
+		ok, _ := path.Match(r.pattern, path.Base(p))
+		return ok
+	}
+	if ok, _ := path.Match(r.pattern, p); ok {
+		return true
+	}
+	// "dir/**" ignores everything below dir
+	if prefix, found := strings.CutSuffix(r.pattern, "/**"); found {
+		return strings.HasPrefix(p, prefix+"/")
+	}
+	return false
+}
+
+func parseIgnoreLine(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	r := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	// A leading "**/" matches at any depth, same as no slash at all
+	line = strings.TrimPrefix(line, "**/")
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	r.pattern = line
+	return r, line != ""
+}
+
+// IgnoreMatcher applies .gitignore files found while walking the tree, the
+// always-ignored folders and any extra patterns from the manifest.
+type IgnoreMatcher struct {
+	rules []ignoreRule
+}
+
+func newIgnoreMatcher(extra []string) *IgnoreMatcher {
+	m := &IgnoreMatcher{}
+	for _, p := range append(append([]string{}, alwaysIgnored...), extra...) {
+		if r, ok := parseIgnoreLine("", p); ok {
+			m.rules = append(m.rules, r)
+		}
+	}
+	return m
+}
+
+// load reads the .gitignore in dir (relative to root), if any.
+func (m *IgnoreMatcher) load(root, dir string) {
+	f, err := os.Open(filepath.Join(root, filepath.FromSlash(dir), ".gitignore"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if r, ok := parseIgnoreLine(dir, scanner.Text()); ok {
+			m.rules = append(m.rules, r)
+		}
+	}
+}
+
+// IgnoredPath reports whether the file rel or any folder above it is
+// excluded, as when walking the tree.
+func (m *IgnoreMatcher) IgnoredPath(rel string) bool {
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.Ignored(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return m.Ignored(rel, false)
+}
+
+// Ignored reports whether rel (slash separated, relative to the root) is
+// excluded. As in git, the last matching rule wins.
+func (m *IgnoreMatcher) Ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range m.rules {
+		if r.match(rel, isDir) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// StreamSpec declares a JetStream stream in the project manifest.
+type StreamSpec struct {
+	Name      string         `yaml:"name"`
+	Subjects  []string       `yaml:"subjects"`
+	Storage   string         `yaml:"storage"`   // file (default) or memory
+	Retention string         `yaml:"retention"` // limits (default), interest or workqueue
+	MaxAge    string         `yaml:"maxAge"`
+	MaxMsgs   int64          `yaml:"maxMsgs"`
+	MaxBytes  int64          `yaml:"maxBytes"`
+	Consumers []ConsumerSpec `yaml:"consumers"`
+}
+
+// ConsumerSpec declares a durable consumer on a stream.
+type ConsumerSpec struct {
+	Name          string `yaml:"name"`
+	FilterSubject string `yaml:"filterSubject"`
+	AckPolicy     string `yaml:"ackPolicy"`     // explicit (default), all or none
+	DeliverPolicy string `yaml:"deliverPolicy"` // all (default), last, new or last_per_subject
+	AckWait       string `yaml:"ackWait"`
+	MaxDeliver    int    `yaml:"maxDeliver"`
+}
+
+// natsJetStreamEnv returns the compose variables that switch JetStream on.
+func natsJetStreamEnv(cfg *Config) []string {
+	if !cfg.Nats.JetStream {
+		return nil
+	}
+	return []string{"NATS_JETSTREAM_ARGS=-js -sd /data"}
+}
+
+func ensureNatsDataDir() error {
+	dir := filepath.Join(getPolycodeDir(), "data", "nats")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create NATS data directory: %w", err)
+	}
+	return nil
+}
+
+// parseEnum converts a manifest value through the JSON form the jetstream
+// package already understands.
+func parseEnum(value string, v interface{}) error {
+	data, _ := json.Marshal(value)
+	return json.Unmarshal(data, v)
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func (s StreamSpec) config() (jetstream.StreamConfig, error) {
+	cfg := jetstream.StreamConfig{
+		Name:     s.Name,
+		Subjects: s.Subjects,
+		MaxMsgs:  s.MaxMsgs,
+		MaxBytes: s.MaxBytes,
+	}
+	if s.MaxMsgs == 0 {
+		cfg.MaxMsgs = -1
+	}
+	if s.MaxBytes == 0 {
+		cfg.MaxBytes = -1
+	}
+
+	if err := parseEnum(firstNonEmpty(s.Storage, "file"), &cfg.Storage); err != nil {
+		return cfg, fmt.Errorf("stream %s: invalid storage: %w", s.Name, err)
+	}
+	if err := parseEnum(firstNonEmpty(s.Retention, "limits"), &cfg.Retention); err != nil {
+		return cfg, fmt.Errorf("stream %s: invalid retention: %w", s.Name, err)
+	}
+
+	maxAge, err := parseDuration(s.MaxAge)
+	if err != nil {
+		return cfg, fmt.Errorf("stream %s: invalid maxAge: %w", s.Name, err)
+	}
+	cfg.MaxAge = maxAge
+	return cfg, nil
+}
+
+func (c ConsumerSpec) config() (jetstream.ConsumerConfig, error) {
+	cfg := jetstream.ConsumerConfig{
+		Durable:       c.Name,
+		FilterSubject: c.FilterSubject,
+		MaxDeliver:    c.MaxDeliver,
+	}
+
+	if err := parseEnum(firstNonEmpty(c.AckPolicy, "explicit"), &cfg.AckPolicy); err != nil {
+		return cfg, fmt.Errorf("consumer %s: invalid ackPolicy: %w", c.Name, err)
+	}
+	if err := parseEnum(firstNonEmpty(c.DeliverPolicy, "all"), &cfg.DeliverPolicy); err != nil {
+		return cfg, fmt.Errorf("consumer %s: invalid deliverPolicy: %w", c.Name, err)
+	}
+
+	ackWait, err := parseDuration(c.AckWait)
+	if err != nil {
+		return cfg, fmt.Errorf("consumer %s: invalid ackWait: %w", c.Name, err)
+	}
+	cfg.AckWait = ackWait
+	return cfg, nil
+}
+
+func connectJetStream(url string) (jetstream.JetStream, func(), error) {
+	nc, err := connectNats(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, nil, fmt.Errorf("failed to open JetStream: %w", err)
+	}
+	return js, nc.Close, nil
+}
+
+// applyStreams creates or updates the streams and consumers in the manifest.
+func applyStreams(url string, specs []StreamSpec) error {
+	if len(specs) == 0 {
+		fmt.Println("No streams declared in " + manifestFile + ".")
+		return nil
+	}
+
+	js, closeConn, err := connectJetStream(url)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, spec := range specs {
+		cfg, err := spec.config()
+		if err != nil {
+			return err
+		}
+		stream, err := js.CreateOrUpdateStream(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to declare stream %s (is JetStream enabled? run `polycode config set nats.jetstream true` and restart the platform): %w", spec.Name, err)
+		}
+		fmt.Println("✅ Stream", spec.Name)
+
+		for _, c := range spec.Consumers {
+			ccfg, err := c.config()
+			if err != nil {
+				return err
+			}
+			if _, err := stream.CreateOrUpdateConsumer(ctx, ccfg); err != nil {
+				return fmt.Errorf("failed to declare consumer %s on %s: %w", c.Name, spec.Name, err)
+			}
+			fmt.Println("   ✅ Consumer", c.Name)
+		}
+	}
+	return nil
+}
+
+func listStreams(url string) error {
+	js, closeConn, err := connectJetStream(url)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lister := js.ListStreams(ctx)
+	count := 0
+	for info := range lister.Info() {
+		fmt.Printf("%-24s msgs=%-8d bytes=%-10d consumers=%-3d subjects=%v\n",
+			info.Config.Name, info.State.Msgs, info.State.Bytes, info.State.Consumers, info.Config.Subjects)
+		count++
+	}
+	if err := lister.Err(); err != nil {
+		return fmt.Errorf("failed to list streams: %w", err)
+	}
+	if count == 0 {
+		fmt.Println("No streams.")
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func streamInfo(url, name string) error {
+	js, closeConn, err := connectJetStream(url)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := js.Stream(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to find stream %s: %w", name, err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get stream %s: %w", name, err)
+	}
+	return printJSON(info)
+}
+
+func purgeStream(url, name, subject string) error {
+	js, closeConn, err := connectJetStream(url)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := js.Stream(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to find stream %s: %w", name, err)
+	}
+
+	var opts []jetstream.StreamPurgeOpt
+	if subject != "" {
+		opts = append(opts, jetstream.WithPurgeSubject(subject))
+	}
+	if err := stream.Purge(ctx, opts...); err != nil {
+		return fmt.Errorf("failed to purge stream %s: %w", name, err)
+	}
+	fmt.Println("🧹 Purged", name)
+	return nil
+}
+
+func listConsumers(url, streamName string) error {
+	js, closeConn, err := connectJetStream(url)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := js.Stream(ctx, streamName)
+	if err != nil {
+		return fmt.Errorf("failed to find stream %s: %w", streamName, err)
+	}
+
+	lister := stream.ListConsumers(ctx)
+	count := 0
+	for info := range lister.Info() {
+		fmt.Printf("%-24s pending=%-8d ack-pending=%-6d redelivered=%-6d filter=%s\n",
+			info.Name, info.NumPending, info.NumAckPending, info.NumRedelivered, info.Config.FilterSubject)
+		count++
+	}
+	if err := lister.Err(); err != nil {
+		return fmt.Errorf("failed to list consumers: %w", err)
+	}
+	if count == 0 {
+		fmt.Println("No consumers.")
+	}
+	return nil
+}
+
+func consumerInfo(url, streamName, name string) error {
+	js, closeConn, err := connectJetStream(url)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	consumer, err := js.Consumer(ctx, streamName, name)
+	if err != nil {
+		return fmt.Errorf("failed to find consumer %s on %s: %w", name, streamName, err)
+	}
+	info, err := consumer.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get consumer %s: %w", name, err)
+	}
+	return printJSON(info)
+}
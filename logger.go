@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go/logging"
+)
+
+type logLevel int
+
+const (
+	levelInfo logLevel = iota
+	levelDebug
+)
+
+// Logger writes diagnostics to stderr at the selected level and everything
+// to the --log-file, when one is given. The emoji status lines stay on
+// stdout and do not go through it.
+type Logger struct {
+	mu    sync.Mutex
+	level logLevel
+	file  io.Writer
+}
+
+var logger = &Logger{}
+
+// setupLogging applies the global -v/--debug and --log-file flags.
+func setupLogging(verbose bool, logFile string) error {
+	if verbose {
+		logger.level = levelDebug
+	}
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		logger.file = f
+	}
+	return nil
+}
+
+// tracing reports whether debug output goes anywhere, so costly details are
+// only gathered when they are wanted.
+func (l *Logger) tracing() bool {
+	return l.level >= levelDebug || l.file != nil
+}
+
+func (l *Logger) logf(level logLevel, format string, args ...interface{}) {
+	if level > l.level && l.file == nil {
+		return
+	}
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level <= l.level {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	if l.file != nil {
+		fmt.Fprintf(l.file, "%s %s\n", time.Now().Format(time.RFC3339), msg)
+	}
+}
+
+func debugf(format string, args ...interface{}) {
+	logger.logf(levelDebug, format, args...)
+}
+
+// warnf reports a problem the command carries on after, at every level.
+func warnf(format string, args ...interface{}) {
+	logger.logf(levelInfo, "⚠️  "+format, args...)
+}
+
+var secretKeyPattern = regexp.MustCompile(`(?i)(PASSWORD|SECRET|TOKEN|CREDENTIAL|SESSION)`)
+
+// maskSecret hides the value of KEY=VALUE pairs whose key names a secret.
+func maskSecret(s string) string {
+	key, _, ok := strings.Cut(s, "=")
+	if ok && secretKeyPattern.MatchString(key) {
+		return key + "=****"
+	}
+	return s
+}
+
+// commandLine renders the arguments and the environment a command adds to
+// ours, with secrets masked.
+func commandLine(cmd *exec.Cmd) string {
+	parts := make([]string, 0, len(cmd.Args))
+	for _, a := range cmd.Args {
+		parts = append(parts, maskSecret(a))
+	}
+	line := strings.Join(parts, " ")
+
+	if cmd.Env != nil {
+		inherited := map[string]bool{}
+		for _, e := range os.Environ() {
+			inherited[e] = true
+		}
+		var added []string
+		for _, e := range cmd.Env {
+			if !inherited[e] {
+				added = append(added, maskSecret(e))
+			}
+		}
+		if len(added) > 0 {
+			line = strings.Join(added, " ") + " " + line
+		}
+	}
+	if cmd.Dir != "" {
+		line += " (in " + cmd.Dir + ")"
+	}
+	return line
+}
+
+// traceCommand logs a command that is started rather than run to
+// completion.
+func traceCommand(cmd *exec.Cmd) {
+	if logger.tracing() {
+		debugf("$ %s", commandLine(cmd))
+	}
+}
+
+// traceResult logs a finished command with the named outputs it captured,
+// e.g. "stdout", out.
+func traceResult(cmd *exec.Cmd, start time.Time, err error, outputs ...interface{}) {
+	if !logger.tracing() {
+		return
+	}
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+	debugf("$ %s\n  %s in %s", commandLine(cmd), status, time.Since(start).Round(time.Millisecond))
+	for i := 0; i+1 < len(outputs); i += 2 {
+		if out := bytes.TrimSpace(outputs[i+1].([]byte)); len(out) > 0 {
+			debugf("  %s:\n%s", outputs[i], indent(string(out)))
+		}
+	}
+}
+
+func indent(s string) string {
+	return "    " + strings.ReplaceAll(s, "\n", "\n    ")
+}
+
+// runCmd runs cmd and traces it. Output the caller does not consume is
+// captured, and on failure its stderr is added to the error instead of a
+// bare "exit status 1".
+func runCmd(cmd *exec.Cmd) error {
+	var stdout, stderr bytes.Buffer
+	if cmd.Stdout == nil {
+		cmd.Stdout = &stdout
+	}
+	captureStderr := cmd.Stderr == nil
+	if captureStderr {
+		cmd.Stderr = &stderr
+	}
+
+	start := time.Now()
+	err := cmd.Run()
+	traceResult(cmd, start, err, "stdout", stdout.Bytes(), "stderr", stderr.Bytes())
+
+	if err != nil && captureStderr {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+	}
+	return err
+}
+
+// cmdOutput is cmd.Output with tracing.
+func cmdOutput(cmd *exec.Cmd) ([]byte, error) {
+	start := time.Now()
+	out, err := cmd.Output()
+
+	var stderr []byte
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		stderr = exitErr.Stderr
+	}
+	traceResult(cmd, start, err, "stdout", out, "stderr", stderr)
+	return out, err
+}
+
+// cmdCombinedOutput is cmd.CombinedOutput with tracing.
+func cmdCombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	traceResult(cmd, start, err, "output", out)
+	return out, err
+}
+
+// awsLogger sends AWS SDK logs to the debug log without the request
+// signature and session token.
+type awsLogger struct{}
+
+var awsSecretHeader = regexp.MustCompile(`(?im)^((?:Authorization|X-Amz-Security-Token):).*$`)
+
+func (awsLogger) Logf(classification logging.Classification, format string, v ...interface{}) {
+	msg := awsSecretHeader.ReplaceAllString(fmt.Sprintf(format, v...), "$1 ****")
+	debugf("aws %s: %s", strings.ToLower(string(classification)), msg)
+}
+
+// loadAWSConfig is config.LoadDefaultConfig with request logging when
+// tracing.
+func loadAWSConfig(ctx context.Context, optFns ...func(*config.LoadOptions) error) (aws.Config, error) {
+	if logger.tracing() {
+		optFns = append(optFns,
+			config.WithLogger(awsLogger{}),
+			config.WithClientLogMode(aws.LogRequest|aws.LogResponse|aws.LogRetries))
+	}
+	return config.LoadDefaultConfig(ctx, optFns...)
+}
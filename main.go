@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	_ "embed"
 	"encoding/base64"
@@ -15,7 +14,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -42,10 +43,14 @@ var EntrypointScript string
 //go:embed resources/Dockerfile
 var Dockerfile string
 
-func hasBuildx() bool {
-	out, err := exec.Command("docker", "buildx", "version").CombinedOutput()
-	return err == nil && len(out) > 0
-}
+//go:embed resources/debug-run.sh
+var DebugRunScript string
+
+//go:embed resources/control.sh
+var ControlScript string
+
+//go:embed resources/buckets.yaml
+var BucketsConfig string
 
 func getPolycodeDir() string {
 	homeDir, err := os.UserHomeDir()
@@ -66,18 +71,33 @@ func getPolycodeDir() string {
 func ensurePolycodeDirAndCopyFiles() error {
 	polycodeDir := getPolycodeDir()
 
-	// Define the files to write
-	files := map[string]string{
+	// Managed files belong to the CLI and are kept in step with it, so an
+	// upgrade takes effect without cleaning ~/.polycode
+	managed := map[string]string{
 		"docker-compose-env.yml":      DockerComposeEnv,
 		"docker-compose-platform.yml": DockerComposePlatform,
 		"entrypoint.sh":               EntrypointScript,
+		"debug-run.sh":                DebugRunScript,
+		"control.sh":                  ControlScript,
 		"Dockerfile":                  Dockerfile,
 	}
+	// User files are only seeded, edits to them are kept
+	seeded := map[string]string{
+		bucketsFile: BucketsConfig,
+	}
 
-	for name, content := range files {
+	for name, content := range managed {
 		targetPath := filepath.Join(polycodeDir, name)
+		if current, err := os.ReadFile(targetPath); err == nil && string(current) == content {
+			continue
+		}
+		if err := os.WriteFile(targetPath, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
 
-		// Only write if the file doesn't already exist
+	for name, content := range seeded {
+		targetPath := filepath.Join(polycodeDir, name)
 		if _, err := os.Stat(targetPath); os.IsNotExist(err) {
 			if err := os.WriteFile(targetPath, []byte(content), 0644); err != nil {
 				return fmt.Errorf("failed to write %s: %w", name, err)
@@ -91,7 +111,7 @@ func ensurePolycodeDirAndCopyFiles() error {
 func syncSidecarFromS3() error {
 	ctx := context.Background()
 
-	cfg, err := config.LoadDefaultConfig(ctx)
+	cfg, err := loadAWSConfig(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to load AWS config: %w", err)
 	}
@@ -180,50 +200,60 @@ func awsStr(s string) *string {
 }
 
 func startPlatform() error {
+	if err := requireEngine(); err != nil {
+		return err
+	}
 	if err := ensurePolycodeDirAndCopyFiles(); err != nil {
 		return fmt.Errorf("copy files: %w", err)
 	}
 	if err := syncSidecarFromS3(); err != nil {
-		return fmt.Errorf("sync sidecar: %w", err)
+		return newCLIError(ErrSidecarSyncFailed, fmt.Errorf("sync sidecar: %w", err))
 	}
 
-	checkCmd := exec.Command("docker", "compose",
-		"-f", "docker-compose-platform.yml",
-		"-p", "polycode-platform",
-		"ps", "--format", "json")
-	checkCmd.Dir = getPolycodeDir()
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	creds, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	inputs := stackInputs{
+		"image platform": composePlatformEnv("docker-compose-platform.yml"),
+		"settings":       platformEnv(cfg),
+		"JetStream":      natsJetStreamEnv(cfg),
+		"credentials":    minioEnv(creds),
+	}
 
-	output, err := checkCmd.Output()
-	if err == nil && len(output) > 0 {
-		// Parse JSON to check if any service is "running"
-		var services []struct {
-			Name  string `json:"Name"`
-			State string `json:"State"`
-		}
-		if err := json.Unmarshal(output, &services); err == nil {
-			for _, svc := range services {
-				if svc.State == "running" {
-					fmt.Println("✅ Platform already started.")
-					return nil
-				}
-			}
+	// JetStream, ports and credentials are fixed when the containers are
+	// created, and NATS only reads its data dir settings on start, so a
+	// running platform is recreated after they change
+	recreate := false
+	if services, err := projectStatus("polycode-platform", "docker-compose-platform.yml", nil); err == nil && anyRunning(services) {
+		changed, known := stackChanges("polycode-platform", "docker-compose-platform.yml", inputs)
+		if known && len(changed) == 0 {
+			fmt.Println("✅ Platform already started.")
+			return nil
 		}
+		fmt.Println(recreateReason("platform", changed, known))
+		recreate = true
 	}
 
-	fmt.Println("Starting platform")
-
-	cmd := exec.Command("docker", "compose",
-		"-f", "docker-compose-platform.yml",
-		"-p", "polycode-platform",
-		"up", "-d")
+	if err := ensureNatsDataDir(); err != nil {
+		return err
+	}
+	if remote := remoteHost(); remote != nil {
+		fmt.Printf("🌐 Starting the platform on %s.\n", remote)
+	} else if err := checkPortConflicts(cfg.Ports.withDefaults()); err != nil {
+		return err
+	}
 
-	// Set working directory to ~/.polycode
-	cmd.Dir = getPolycodeDir()
+	fmt.Println("Starting platform")
 
-	// Execute the command
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker compose failed: %w", err)
+	if err := stackUp("polycode-platform", "docker-compose-platform.yml", inputs.env(), recreate, nil); err != nil {
+		return err
 	}
+	saveStackStamp("polycode-platform", "docker-compose-platform.yml", inputs)
 
 	time.Sleep(3 * time.Second)
 	fmt.Println("✅ Platform started.")
@@ -231,19 +261,14 @@ func startPlatform() error {
 }
 
 func stopPlatform() error {
-	fmt.Println("Stopping platform")
-
-	cmd := exec.Command("docker", "compose",
-		"-f", "docker-compose-platform.yml",
-		"-p", "polycode-platform",
-		"down")
+	if err := requireEngine(); err != nil {
+		return err
+	}
 
-	// Set working directory to ~/.polycode
-	cmd.Dir = getPolycodeDir()
+	fmt.Println("Stopping platform")
 
-	// Execute the command
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker compose failed: %w", err)
+	if err := stackDown("polycode-platform", "docker-compose-platform.yml", nil); err != nil {
+		return err
 	}
 
 	time.Sleep(3 * time.Second)
@@ -252,63 +277,108 @@ func stopPlatform() error {
 }
 
 func psPlatform() error {
-	fmt.Println("Checking platform status...")
-
-	cmd := exec.Command("docker", "compose",
-		"-f", "docker-compose-platform.yml",
-		"-p", "polycode-platform",
-		"ps", "--format", "json")
-
-	cmd.Dir = getPolycodeDir()
+	if err := requireEngine(); err != nil {
+		return err
+	}
 
-	var out bytes.Buffer
-	cmd.Stdout = &out
+	fmt.Println("Checking platform status...")
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker compose ps failed: %w", err)
+	services, err := projectStatus("polycode-platform", "docker-compose-platform.yml", nil)
+	if err != nil {
+		return err
 	}
+	printProjectStatus(services)
+	return nil
+}
 
-	var containers []struct {
-		Name  string `json:"Name"`
-		State string `json:"State"` // e.g., "running", "exited"
+// platformLogs prints the logs of the platform services, or only of service.
+func platformLogs(service string, follow bool, tail string) error {
+	api := engineAPI()
+	if api == nil {
+		args := []string{"-f", "docker-compose-platform.yml", "-p", "polycode-platform", "logs", "--tail", firstNonEmpty(tail, "all")}
+		if follow {
+			args = append(args, "-f")
+		}
+		if service != "" {
+			args = append(args, service)
+		}
+		cmd := containerEngine().Compose(args...)
+		cmd.Dir = getPolycodeDir()
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return runCmd(cmd)
 	}
 
-	if err := json.Unmarshal(out.Bytes(), &containers); err != nil {
-		return fmt.Errorf("failed to parse docker compose ps output: %w", err)
-	}
+	ctx, cancel := interruptContext()
+	defer cancel()
 
+	containers, err := api.ProjectContainers(ctx, "polycode-platform")
+	if err != nil {
+		return err
+	}
 	if len(containers) == 0 {
-		fmt.Println("STOPPED")
-		return nil
+		return fmt.Errorf("platform is not running, start it with `polycode platform start`")
 	}
 
-	allRunning := true
-	anyExited := false
-
+	var g sync.WaitGroup
+	errs := make(chan error, len(containers))
+	found := false
 	for _, c := range containers {
-		state := strings.ToLower(c.State)
-		switch state {
-		case "running":
-			// good
-		case "exited", "dead", "removing":
-			anyExited = true
-			allRunning = false
-		default:
-			allRunning = false
+		name := c.Labels[labelComposeService]
+		if service != "" && name != service {
+			continue
 		}
+		found = true
+
+		g.Add(1)
+		go func(id, name string) {
+			defer g.Done()
+			stdout := newPrefixWriter(os.Stdout, name+" | ")
+			stderr := newPrefixWriter(os.Stderr, name+" | ")
+			errs <- api.ContainerLogs(ctx, id, follow, tail, stdout, stderr)
+		}(c.ID, name)
 	}
-
-	if allRunning {
-		fmt.Println("RUNNING")
-	} else if anyExited {
-		fmt.Println("ERROR")
-	} else {
-		fmt.Println("STOPPED")
+	if !found {
+		return fmt.Errorf("no platform container for service '%s'", service)
 	}
 
+	g.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// platformEvents prints container events of the platform until Ctrl-C.
+func platformEvents() error {
+	api := engineAPI()
+	if api == nil {
+		return fmt.Errorf("%s has no reachable engine API to stream events from", containerEngine().Name())
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	events, errs := api.Events(ctx, map[string][]string{
+		"type":  {"container"},
+		"label": {labelComposeProject + "=polycode-platform"},
+	})
+	fmt.Println("👂 Watching platform events, Ctrl-C to stop.")
+	for ev := range events {
+		fmt.Printf("[%s] %-10s %s\n", time.Unix(0, ev.TimeNano).Format("15:04:05.000"),
+			ev.Action, ev.Actor.Attributes[labelComposeService])
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
 func attr(name string, t types.ScalarAttributeType) types.AttributeDefinition {
 	return types.AttributeDefinition{AttributeName: aws.String(name), AttributeType: t}
 }
@@ -342,7 +412,9 @@ func ensureBucket(ctx context.Context, s3client *s3.Client, bucketName string) e
 			}
 			return nil
 		case "Forbidden":
-			return nil
+			return fmt.Errorf("access to bucket %s was denied: the credentials in %s do not match the running MinIO. "+
+				"Restart the platform with `polycode platform stop && polycode platform start`, "+
+				"or reset the local data with `polycode platform clean`", bucketName, credentialsPath())
 		default:
 			return fmt.Errorf("unexpected API error on HeadBucket: %w", err)
 		}
@@ -351,25 +423,47 @@ func ensureBucket(ctx context.Context, s3client *s3.Client, bucketName string) e
 	return fmt.Errorf("failed to check or create bucket: %w", err)
 }
 
-func setupPlatform(ctx context.Context) error {
-	dummyCredentials := aws.NewCredentialsCache(
+const filesBucket = "polycode-files"
+
+// devAWSConfig returns the AWS config for the local DynamoDB and MinIO.
+func devAWSConfig(ctx context.Context) (aws.Config, error) {
+	creds, err := loadCredentials()
+	if err != nil {
+		return aws.Config{}, err
+	}
+	provider := aws.NewCredentialsCache(
 		credentials.NewStaticCredentialsProvider(
-			"minioadmin",
-			"minioadmin",
+			creds.AccessKey,
+			creds.SecretKey,
 			"",
 		),
 	)
 
-	cfg, err := config.LoadDefaultConfig(ctx,
+	cfg, err := loadAWSConfig(ctx,
 		config.WithRegion("us-east-1"),
-		config.WithCredentialsProvider(dummyCredentials),
+		config.WithCredentialsProvider(provider),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to load dev mode aws config: %w", err)
+		return aws.Config{}, fmt.Errorf("failed to load dev mode aws config: %w", err)
+	}
+	return cfg, nil
+}
+
+func devS3Client(cfg aws.Config) *s3.Client {
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(platformPorts().s3Endpoint())
+		o.UsePathStyle = true
+	})
+}
+
+func setupPlatform(ctx context.Context) error {
+	cfg, err := devAWSConfig(ctx)
+	if err != nil {
+		return err
 	}
 
 	ddb := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
-		o.BaseEndpoint = aws.String("http://localhost:8000/")
+		o.BaseEndpoint = aws.String(platformPorts().dynamoEndpoint())
 	})
 
 	tables := []struct {
@@ -508,113 +602,165 @@ func setupPlatform(ctx context.Context) error {
 		}
 	}
 
-	// Set up MinIO S3 bucket
-	s3client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String("http://localhost:9000/")
-		o.UsePathStyle = true
-	})
-	bucketName := "polycode-files"
+	// Set up MinIO S3 buckets
+	buckets, err := loadBucketSpecs()
+	if err != nil {
+		return err
+	}
+	s3client := devS3Client(cfg)
+	if err := provisionBuckets(ctx, s3client, buckets); err != nil {
+		return err
+	}
 
-	err = ensureBucket(ctx, s3client, bucketName)
+	polyCfg, err := loadConfig()
 	if err != nil {
 		return err
 	}
+	registerFilesEvents(ctx, s3client, polyCfg, buckets)
 
 	fmt.Println("🚀 Platform ready!")
 	return nil
 }
 
-func loginDockerRegistries() error {
+// ecrPassword returns the password of an ECR authorization token for the
+// profile's registry; the user name is always AWS.
+func ecrPassword(registry RegistryConfig) (string, error) {
 	ctx := context.Background()
 
 	// === Load AWS Config
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-east-1"))
+	cfg, err := loadAWSConfig(ctx, config.WithRegion(registry.Region))
 	if err != nil {
-		return fmt.Errorf("failed to load AWS config: %w", err)
+		return "", fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
 	// === Get ECR auth token
 	ecrClient := ecr.NewFromConfig(cfg)
 	authOutput, err := ecrClient.GetAuthorizationToken(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to get ECR auth token: %w", err)
+		return "", fmt.Errorf("failed to get ECR auth token: %w", err)
 	}
 	if len(authOutput.AuthorizationData) == 0 {
-		return fmt.Errorf("no authorization data from ECR")
+		return "", fmt.Errorf("no authorization data from ECR")
 	}
 
 	authData := authOutput.AuthorizationData[0]
 	decoded, err := base64.StdEncoding.DecodeString(*authData.AuthorizationToken)
 	if err != nil {
-		return fmt.Errorf("failed to decode auth token: %w", err)
+		return "", fmt.Errorf("failed to decode auth token: %w", err)
 	}
 	parts := strings.SplitN(string(decoded), ":", 2)
 	if len(parts) != 2 {
-		return fmt.Errorf("invalid authorization token format")
+		return "", fmt.Errorf("invalid authorization token format")
 	}
-	password := parts[1]
+	return parts[1], nil
+}
 
-	for _, account := range ecrAccounts {
-		registry := fmt.Sprintf("%s.dkr.ecr.us-east-1.amazonaws.com", account)
-		cmd := exec.Command("docker", "login", "--username", "AWS", "--password-stdin", registry)
+func loginDockerRegistries(registry RegistryConfig) error {
+	password, err := ecrPassword(registry)
+	if err != nil {
+		return err
+	}
+
+	for _, account := range registry.Accounts {
+		host := ecrHost(account, registry.Region)
+		cmd := containerEngine().Command("login", "--username", "AWS", "--password-stdin", host)
 		cmd.Stdin = strings.NewReader(password)
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("docker login failed for %s: %w", registry, err)
+		if err := runCmd(cmd); err != nil {
+			return fmt.Errorf("%s login failed for %s: %w", containerEngine().Name(), host, err)
 		}
 	}
 
 	return nil
 }
 
+// registryAuths returns the X-Registry-Auth value of every registry host of
+// the profile, for pulls through the engine API.
+func registryAuths(registry RegistryConfig) (map[string]string, error) {
+	password, err := ecrPassword(registry)
+	if err != nil {
+		return nil, err
+	}
+
+	auths := map[string]string{}
+	for _, account := range registry.Accounts {
+		host := ecrHost(account, registry.Region)
+		data, err := json.Marshal(map[string]string{"username": "AWS", "password": password, "serveraddress": host})
+		if err != nil {
+			return nil, err
+		}
+		auths[host] = base64.URLEncoding.EncodeToString(data)
+	}
+	return auths, nil
+}
+
 func startEnvironment(envID string) error {
 	if envID == "" {
 		return fmt.Errorf("environment ID is required")
 	}
-
-	checkCmd := exec.Command("docker", "compose",
-		"-f", "docker-compose-env.yml",
-		"-p", "polycode-env-"+envID,
-		"ps", "--format", "json")
-	checkCmd.Dir = getPolycodeDir()
-
-	output, err := checkCmd.Output()
-	if err == nil && len(output) > 0 {
-		// Parse JSON to check if any service is "running"
-		var services []struct {
-			Name  string `json:"Name"`
-			State string `json:"State"`
-		}
-		if err := json.Unmarshal(output, &services); err == nil {
-			for _, svc := range services {
-				if svc.State == "running" {
-					fmt.Println("✅ Environment already started.")
-					return nil
-				}
-			}
-		}
+	if err := requireEngine(); err != nil {
+		return err
+	}
+	if err := ensurePolycodeDirAndCopyFiles(); err != nil {
+		return fmt.Errorf("copy files: %w", err)
 	}
 
-	err = loginDockerRegistries()
+	profile, err := activeProfile()
+	if err != nil {
+		return err
+	}
+	creds, err := loadCredentials()
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Starting environment...")
+	project := "polycode-env-" + envID
+	inputs := stackInputs{
+		"environment": {"ENVIRONMENT_ID=" + envID}, // ✅ set ENVIRONMENT_ID for docker-compose
+		"profile":     profileEnv(profile),
+		"credentials": credentialsEnv(creds),
+	}
+	inputs["image platform"] = composePlatformEnv("docker-compose-env.yml", inputs.env()...)
+
+	// Compose leaves a service alone when only files it mounts changed, so a
+	// changed stack is recreated as a whole
+	recreate := false
+	if services, err := projectStatus(project, "docker-compose-env.yml", inputs.env()); err == nil && anyRunning(services) {
+		changed, known := stackChanges(project, "docker-compose-env.yml", inputs)
+		if known && len(changed) == 0 {
+			fmt.Println("✅ Environment already started.")
+			return nil
+		}
+		fmt.Println(recreateReason("environment", changed, known))
+		recreate = true
+	}
 
-	cmd := exec.Command("docker", "compose",
-		"-f", "docker-compose-env.yml",
-		"-p", "polycode-env-"+envID,
-		"up", "-d")
+	// The environment joins the platform network, compose only reports a
+	// missing external network after pulling every image
+	if api := engineAPI(); api != nil {
+		exists, err := api.NetworkExists(context.Background(), "polycode-dev")
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("network polycode-dev does not exist, run `polycode platform start` first")
+		}
+	}
 
-	// Set working directory to ~/.polycode
-	cmd.Dir = getPolycodeDir()
+	// The environment mounts the sidecar from ./runtime, which compose
+	// resolves to the local path
+	if remote := remoteHost(); remote != nil {
+		fmt.Printf("📤 Copying the runtime to %s...\n", remote)
+		if err := remote.pushDir(filepath.Join(getPolycodeDir(), "runtime")); err != nil {
+			return err
+		}
+	}
 
-	cmd.Env = append(os.Environ(), "ENVIRONMENT_ID="+envID) // ✅ set ENVIRONMENT_ID for docker-compose
+	fmt.Println("Starting environment...")
 
-	// Execute the command
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker compose failed: %w", err)
+	if err := stackUp(project, "docker-compose-env.yml", inputs.env(), recreate, &profile.Registry); err != nil {
+		return err
 	}
+	saveStackStamp(project, "docker-compose-env.yml", inputs)
 
 	time.Sleep(3 * time.Second)
 	fmt.Printf("🚀 Environment %s ready!\n", envID)
@@ -625,22 +771,15 @@ func stopEnvironment(envID string) error {
 	if envID == "" {
 		return fmt.Errorf("environment ID is required")
 	}
+	if err := requireEngine(); err != nil {
+		return err
+	}
 
 	fmt.Println("Stopping environment...")
 
-	cmd := exec.Command("docker", "compose",
-		"-f", "docker-compose-env.yml",
-		"-p", "polycode-env-"+envID,
-		"down")
-
-	// Set working directory to ~/.polycode
-	cmd.Dir = getPolycodeDir()
-
-	cmd.Env = append(os.Environ(), "ENVIRONMENT_ID="+envID) // ✅ set ENVIRONMENT_ID for docker-compose
-
-	// Execute the command
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker compose failed: %w", err)
+	// ✅ set ENVIRONMENT_ID for docker-compose
+	if err := stackDown("polycode-env-"+envID, "docker-compose-env.yml", []string{"ENVIRONMENT_ID=" + envID}); err != nil {
+		return err
 	}
 
 	time.Sleep(3 * time.Second)
@@ -652,62 +791,17 @@ func psEnvironment(envID string) error {
 	if envID == "" {
 		return fmt.Errorf("environment ID is required")
 	}
-
-	fmt.Println("Checking environment status...")
-
-	cmd := exec.Command("docker", "compose",
-		"-f", "docker-compose-env.yml",
-		"-p", "polycode-env-"+envID,
-		"ps", "--format", "json")
-
-	cmd.Dir = getPolycodeDir()
-	cmd.Env = append(os.Environ(), "ENVIRONMENT_ID="+envID)
-
-	var out bytes.Buffer
-	cmd.Stdout = &out
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker compose ps failed: %w", err)
-	}
-
-	var containers []struct {
-		Name  string `json:"Name"`
-		State string `json:"State"` // e.g., "running", "exited", etc.
-	}
-
-	if err := json.Unmarshal(out.Bytes(), &containers); err != nil {
-		return fmt.Errorf("failed to parse docker compose ps output: %w", err)
-	}
-
-	if len(containers) == 0 {
-		fmt.Println("STOPPED")
-		return nil
+	if err := requireEngine(); err != nil {
+		return err
 	}
 
-	allRunning := true
-	anyExited := false
-
-	for _, c := range containers {
-		state := strings.ToLower(c.State)
-		switch state {
-		case "running":
-			// OK
-		case "exited", "dead", "removing":
-			anyExited = true
-			allRunning = false
-		default:
-			allRunning = false
-		}
-	}
+	fmt.Println("Checking environment status...")
 
-	if allRunning {
-		fmt.Println("RUNNING")
-	} else if anyExited {
-		fmt.Println("ERROR")
-	} else {
-		fmt.Println("STOPPED")
+	services, err := projectStatus("polycode-env-"+envID, "docker-compose-env.yml", []string{"ENVIRONMENT_ID=" + envID})
+	if err != nil {
+		return err
 	}
-
+	printProjectStatus(services)
 	return nil
 }
 
@@ -722,7 +816,19 @@ func cleanPlatform() error {
 	return nil
 }
 
-func runApp(appPath string, envID string, hostPort string) error {
+type RunOptions struct {
+	// Debug runs the app under a headless Delve server.
+	Debug bool
+	// WaitForDebugger holds the app at startup until a debugger attaches.
+	WaitForDebugger bool
+	// Sync selects how the project gets into the container; empty uses the
+	// manifest setting or bind mounts.
+	Sync string
+	// ProjectRoot overrides the discovered project root.
+	ProjectRoot string
+}
+
+func runApp(appPath string, envID string, hostPort string, buildOpts BuildOptions, runOpts RunOptions) error {
 	absAppPath, err := filepath.Abs(appPath)
 	if err != nil {
 		return fmt.Errorf("invalid app path: %w", err)
@@ -732,103 +838,311 @@ func runApp(appPath string, envID string, hostPort string) error {
 		return fmt.Errorf("app folder '%s' does not exist", absAppPath)
 	}
 
+	profile, err := activeProfile()
+	if err != nil {
+		return err
+	}
+
 	appName := filepath.Base(absAppPath)
-	projectRoot, err := getGitRoot(absAppPath)
+	projectRoot, foundBy, err := findProjectRoot(absAppPath, runOpts.ProjectRoot)
+	if err != nil {
+		return err
+	}
+	appFolder, err := appFolderIn(projectRoot, absAppPath)
 	if err != nil {
-		return fmt.Errorf("detecting git root: %w", err)
+		return fmt.Errorf("failed to locate app in project: %w", err)
 	}
+	fmt.Printf("📁 Project root %s (from %s), app folder %s\n", projectRoot, foundBy, appFolder)
 
-	serviceIDs, _ := findServiceIDs(filepath.Join(absAppPath, "services"))
+	manifest, err := loadManifest(absAppPath)
+	if err != nil {
+		return err
+	}
+	rt, err := resolveRuntime(absAppPath, manifest)
+	if err != nil {
+		return err
+	}
+
+	services, err := discoverServices(absAppPath, rt.Name)
+	if err != nil {
+		return err
+	}
+	serviceIDs := strings.Join(services.IDs(), ",")
+	fmt.Printf("🧭 Services: %s\n", firstNonEmpty(strings.Join(services.IDs(), ", "), "none"))
+	if rt.Name == "go" {
+		ws, err := findGoWorkspace(projectRoot, absAppPath)
+		if err != nil {
+			return err
+		}
+		if ws != nil {
+			if rt, err = applyGoWorkspace(rt, ws, projectRoot, absAppPath); err != nil {
+				return err
+			}
+			fmt.Printf("🧩 Go workspace %s with %d modules\n", filepath.Join(ws.Dir, workspaceFile), len(ws.Modules))
+		}
+	}
+	toolchain, err := resolveToolchain(manifest, rt, profile.Registry)
+	if err != nil {
+		return err
+	}
+	remote := remoteHost()
+	defaultSync := SyncBind
+	if remote != nil {
+		// The project only exists on the laptop, keep a synced copy in a
+		// volume on the remote host instead
+		defaultSync = SyncMutagenLike
+	}
+	syncMode, err := parseSyncMode(firstNonEmpty(runOpts.Sync, manifest.Sync.Mode, string(defaultSync)))
+	if err != nil {
+		return err
+	}
+	if remote != nil && syncMode == SyncBind {
+		fmt.Printf("⚠️  Cannot bind-mount the project on %s, using %s sync.\n", remote, SyncMutagenLike)
+		syncMode = SyncMutagenLike
+	}
 
-	appFolder := "."
-	if absAppPath != projectRoot {
-		appFolder = appName
+	if err := ensurePolycodeDirAndCopyFiles(); err != nil {
+		return fmt.Errorf("copy files: %w", err)
 	}
 
 	imageTag := fmt.Sprintf("%s:latest", appName)
 	fmt.Println("🛠️  Building image:", imageTag)
-	err = dockerBuild(projectRoot, appFolder, imageTag)
+	platform := buildPlatform(toolchain.BaseImage, toolchain.BuilderImage)
+	err = dockerBuild(projectRoot, appName, appFolder, imageTag, platform, toolchain, buildOpts)
 	if err != nil {
 		return fmt.Errorf("docker build failed: %w", err)
 	}
 
-	// Build docker run command
-	runArgs := []string{
-		"run", "--rm", "-it",
-		"--network", "polycode-dev",
-		"-p", "2345:2345",
-		"-v", fmt.Sprintf("%s:/project", projectRoot),
-		"-e", "polycode_DEV_MODE=true",
-		"-e", "polycode_ORG_ID=xxx",
-		"-e", fmt.Sprintf("polycode_ENV_ID=%s", envID),
-		"-e", fmt.Sprintf("polycode_APP_NAME=%s", appName),
-		"-e", fmt.Sprintf("polycode_SERVICE_IDS=%s", serviceIDs),
+	control, err := startControlServer(appName)
+	if err != nil {
+		return err
+	}
+	defer control.Close(appName)
+
+	containerName := "polycode-app-" + appName
+	ports := platformPorts()
+	debugHostPort := orDefault(ports.Debug, rt.DebugPort)
+	if portInUse(debugHostPort) {
+		return newCLIError(ErrPortInUse, fmt.Errorf("debug port %d is in use", debugHostPort)).
+			withHint("Stop the other debug session, or remap the port with `polycode config set ports.debug <port>`.")
 	}
 
+	spec := containerSpec{
+		Name:       containerName,
+		Image:      imageTag,
+		Platform:   platform,
+		Network:    "polycode-dev",
+		AutoRemove: true,
+		Ports:      []portMapping{{HostPort: debugHostPort, ContainerPort: rt.DebugPort}},
+	}
+	applySync(&spec, syncMode, projectRoot, appName)
+	control.apply(&spec)
+	spec.Env = append(spec.Env,
+		"polycode_DEV_MODE=true",
+		fmt.Sprintf("polycode_ORG_ID=%s", profile.OrgID),
+		fmt.Sprintf("polycode_ENV_ID=%s", envID),
+		fmt.Sprintf("polycode_APP_NAME=%s", appName),
+		fmt.Sprintf("polycode_SERVICE_IDS=%s", serviceIDs),
+	)
+	spec.Env = append(spec.Env, ports.appEnv()...)
+	creds, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+	spec.Env = append(spec.Env, credentialsEnv(creds)...)
+	spec.Env = append(spec.Env, rt.containerEnv()...)
+
+	if runOpts.Debug {
+		env, err := debugEnv(rt, runOpts)
+		if err != nil {
+			return err
+		}
+		spec.Env = append(spec.Env, env...)
+		applyDebug(&spec)
+		writeIDEConfigs(projectRoot, appName, debugHostPort)
+	}
+
+	forwarded := []int{debugHostPort}
 	if hostPort != "" {
-		runArgs = append(runArgs, "-p", fmt.Sprintf("%s:8080", hostPort))
+		p, err := strconv.Atoi(hostPort)
+		if err != nil {
+			return fmt.Errorf("invalid host port '%s'", hostPort)
+		}
+		spec.Ports = append(spec.Ports, portMapping{HostPort: p, ContainerPort: 8080})
+		forwarded = append(forwarded, p)
 	}
 
-	runArgs = append(runArgs, imageTag)
+	if remote != nil {
+		stop, err := remote.forward(forwarded, []int{control.Port()})
+		if err != nil {
+			fmt.Println("⚠️ ", err)
+			fmt.Printf("   The app and debugger ports stay on %s.\n", remote)
+		} else {
+			defer stop()
+			fmt.Printf("🔌 Forwarding %s from %s.\n", joinPorts(forwarded), remote)
+		}
+	}
 
 	fmt.Println("🚀 Running container...")
-	cmd := exec.Command("docker", runArgs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-	return cmd.Run()
+	if syncMode == SyncBind {
+		return runContainer(spec)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// The container waits for /tmp/sync-ready, so a failed initial sync
+	// stops it rather than leaving it waiting forever
+	syncFailed := make(chan error, 1)
+	go func() {
+		err := waitForContainer(containerName, 30*time.Second)
+		if err == nil {
+			syncer := newSyncer(syncMode, containerName, projectRoot, appFolder, manifest.Sync.Exclude)
+			if err = syncer.initial(); err == nil {
+				syncer.watch(done)
+				return
+			}
+		}
+		syncFailed <- err
+		if stopErr := stopContainer(containerName); stopErr != nil {
+			fmt.Println("⚠️  sync:", stopErr)
+		}
+	}()
+
+	err = runContainer(spec)
+	select {
+	case syncErr := <-syncFailed:
+		return fmt.Errorf("initial sync failed: %w", syncErr)
+	default:
+	}
+	return err
 }
 
 func getGitRoot(path string) (string, error) {
 	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
 	cmd.Dir = path
-	out, err := cmd.Output()
+	out, err := cmdOutput(cmd)
 	if err != nil {
-		return "", fmt.Errorf("failed to detect git root: %w", err)
+		return "", newCLIError(ErrNotAGitRepo, fmt.Errorf("%s is not inside a git repository: %w", path, err))
 	}
 	return strings.TrimSpace(string(out)), nil
 }
 
-func findServiceIDs(path string) (string, error) {
-	entries, err := os.ReadDir(path)
-	if err != nil {
-		return "", nil // okay if no services
+func dockerBuild(contextDir, appName, appFolder, imageTag, platform string, toolchain Toolchain, opts BuildOptions) error {
+	if err := requireEngine(); err != nil {
+		return err
 	}
-	var names []string
-	for _, e := range entries {
-		if e.IsDir() {
-			names = append(names, e.Name())
-		}
+	engine := containerEngine()
+	caps := engine.Capabilities()
+	if !caps.BuildContexts {
+		return fmt.Errorf("%s cannot build with named build contexts (for docker, install buildx)", engine.Name())
 	}
-	return strings.Join(names, ","), nil
-}
 
-func dockerBuild(contextDir, appFolder, imageTag string) error {
-	if !hasBuildx() {
-		return fmt.Errorf("docker buildx is not installed")
+	fingerprint, baseDigest, err := buildFingerprint(appFolder, platform, toolchain)
+	if err != nil {
+		fmt.Println("⚠️  Cannot check whether the image is up to date:", err)
+	} else if !opts.Force && upToDate(imageTag, fingerprint) {
+		fmt.Println("✅ Image is up to date, skipping build.")
+		return nil
 	}
 
 	dockerfilePath := filepath.Join(getPolycodeDir(), "Dockerfile")
 
-	cmd := exec.Command(
-		"docker", "build",
-		"--load",
+	args := []string{
+		"--platform", platform,
 		"--build-arg", fmt.Sprintf("APP_FOLDER=%s", appFolder),
 		"--build-context", fmt.Sprintf("platform=%s", getPolycodeDir()),
 		"-t", imageTag,
 		"-f", dockerfilePath, // explicitly set Dockerfile path
-		".", // set build context
-	)
+	}
+	args = append(args, toolchain.buildArgs()...)
+	args = append(args, toolchainLabels(appName, toolchain, baseDigest)...)
+
+	var commitCache func() error
+	if opts.ExportCache && !caps.CacheExport {
+		fmt.Printf("⚠️  %s cannot export a local build cache, building without it.\n", engine.Name())
+	} else if opts.ExportCache {
+		var cache []string
+		cache, commitCache = cacheArgs(imageTag)
+		args = append(args, cache...)
+	}
+
+	args = append(args, ".") // set build context
+
+	cmd := engine.Build(args...)
 	cmd.Dir = contextDir
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	if err := runCmd(cmd); err != nil {
+		return err
+	}
+
+	if commitCache != nil {
+		if err := commitCache(); err != nil {
+			return err
+		}
+	}
+
+	if fingerprint != "" {
+		if err := os.WriteFile(fingerprintPath(imageTag), []byte(fingerprint), 0644); err != nil {
+			return fmt.Errorf("failed to write build fingerprint: %w", err)
+		}
+	}
+	return nil
+}
+
+// jsonOutput reports whether a command prints JSON. --json after a command
+// means the same as the global flag, so it switches failures to the JSON
+// envelope too.
+func jsonOutput(c *cli.Context) bool {
+	if c.Bool("json") {
+		jsonErrors = true
+	}
+	return jsonErrors
+}
+
+// natsServerURL and natsMonitorURL default to the platform's ports when the
+// flags are not given; the config is read here rather than while building
+// the commands, so other commands never touch it.
+func natsServerURL(c *cli.Context) string {
+	return firstNonEmpty(c.String("server"), platformPorts().natsURL())
+}
+
+func natsMonitorURL(c *cli.Context) string {
+	return firstNonEmpty(c.String("monitor"), platformPorts().monitorURL())
+}
+
+func appControlCommand(name, usage string) *cli.Command {
+	return &cli.Command{
+		Name:  name,
+		Usage: usage,
+		Action: func(c *cli.Context) error {
+			appName := c.String("app")
+			if appName == "" {
+				wd, err := os.Getwd()
+				if err != nil {
+					return fmt.Errorf("failed to get current directory: %w", err)
+				}
+				appName = filepath.Base(wd)
+			}
+			return sendControlCommand(appName, name)
+		},
+	}
 }
 
 func main() {
 	app := &cli.App{
 		Name:  "polycode",
 		Usage: "Manage the local Polycode platform",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "json", Usage: "Report failures as a JSON error envelope on stderr"},
+			&cli.BoolFlag{Name: "verbose", Aliases: []string{"v", "debug"}, Usage: "Trace external commands and AWS requests on stderr"},
+			&cli.StringFlag{Name: "log-file", Usage: "Append a debug log to `FILE`"},
+		},
+		Before: func(c *cli.Context) error {
+			jsonErrors = c.Bool("json")
+			return setupLogging(c.Bool("verbose"), c.String("log-file"))
+		},
 		Commands: []*cli.Command{
 			{
 				Name:  "platform",
@@ -839,7 +1153,7 @@ func main() {
 						Usage: "Start the platform (Docker, DynamoDB, S3)",
 						Action: func(c *cli.Context) error {
 							if err := startPlatform(); err != nil {
-								return fmt.Errorf("start docker: %w", err)
+								return fmt.Errorf("start platform: %w", err)
 							}
 							if err := setupPlatform(context.Background()); err != nil {
 								return fmt.Errorf("setup platform: %w", err)
@@ -852,7 +1166,7 @@ func main() {
 						Usage: "Stop the platform",
 						Action: func(c *cli.Context) error {
 							if err := stopPlatform(); err != nil {
-								return fmt.Errorf("stop docker: %w", err)
+								return fmt.Errorf("stop platform: %w", err)
 							}
 							return nil
 						},
@@ -862,21 +1176,47 @@ func main() {
 						Usage: "View the status of the platform",
 						Action: func(c *cli.Context) error {
 							if err := psPlatform(); err != nil {
-								return fmt.Errorf("stop docker: %w", err)
+								return fmt.Errorf("platform status: %w", err)
 							}
 							return nil
 						},
 					},
+					{
+						Name:      "logs",
+						Usage:     "Print the logs of the platform services",
+						ArgsUsage: "[service]",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "follow", Aliases: []string{"f"}, Usage: "Keep streaming new output"},
+							&cli.StringFlag{Name: "tail", Usage: "Number of lines to show from the end", Value: "100"},
+						},
+						Action: func(c *cli.Context) error {
+							return platformLogs(c.Args().First(), c.Bool("follow"), c.String("tail"))
+						},
+					},
+					{
+						Name:  "events",
+						Usage: "Stream container events of the platform",
+						Action: func(c *cli.Context) error {
+							return platformEvents()
+						},
+					},
+					{
+						Name:  "credentials",
+						Usage: "Show the credentials of the local MinIO and DynamoDB",
+						Action: func(c *cli.Context) error {
+							return showCredentials()
+						},
+					},
 					{
 						Name:  "clean",
 						Usage: "Clean the platform",
 						Action: func(c *cli.Context) error {
 							if err := stopPlatform(); err != nil {
-								return fmt.Errorf("stop docker: %w", err)
+								return fmt.Errorf("stop platform: %w", err)
 							}
 
 							if err := cleanPlatform(); err != nil {
-								return fmt.Errorf("stop command: %w", err)
+								return fmt.Errorf("clean platform: %w", err)
 							}
 							return nil
 						},
@@ -890,15 +1230,15 @@ func main() {
 					{
 						Name:      "start",
 						Usage:     "Start an environment",
-						ArgsUsage: "<environment-id>",
+						ArgsUsage: "[environment-id]",
 						Action: func(c *cli.Context) error {
-							if c.Args().Len() < 1 {
-								return fmt.Errorf("missing <environment-id>")
+							envID, err := resolveEnvID(c.Args().Get(0))
+							if err != nil {
+								return err
 							}
-							envID := c.Args().Get(0)
 
 							if err := startEnvironment(envID); err != nil {
-								return fmt.Errorf("stop docker: %w", err)
+								return fmt.Errorf("start environment: %w", err)
 							}
 							return nil
 						},
@@ -906,15 +1246,15 @@ func main() {
 					{
 						Name:      "stop",
 						Usage:     "Stop an environment",
-						ArgsUsage: "<environment-id>",
+						ArgsUsage: "[environment-id]",
 						Action: func(c *cli.Context) error {
-							if c.Args().Len() < 1 {
-								return fmt.Errorf("missing <environment-id>")
+							envID, err := resolveEnvID(c.Args().Get(0))
+							if err != nil {
+								return err
 							}
-							envID := c.Args().Get(0)
 
 							if err := stopEnvironment(envID); err != nil {
-								return fmt.Errorf("stop docker: %w", err)
+								return fmt.Errorf("stop environment: %w", err)
 							}
 							return nil
 						},
@@ -922,31 +1262,432 @@ func main() {
 					{
 						Name:      "status",
 						Usage:     "View the status of an environment",
-						ArgsUsage: "<environment-id>",
+						ArgsUsage: "[environment-id]",
 						Action: func(c *cli.Context) error {
-							if c.Args().Len() < 1 {
-								return fmt.Errorf("missing <environment-id>")
+							envID, err := resolveEnvID(c.Args().Get(0))
+							if err != nil {
+								return err
 							}
-							envID := c.Args().Get(0)
 
 							if err := psEnvironment(envID); err != nil {
-								return fmt.Errorf("stop docker: %w", err)
+								return fmt.Errorf("environment status: %w", err)
 							}
 							return nil
 						},
 					},
 				},
 			},
+			{
+				Name:  "profile",
+				Usage: "Manage org profiles",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "create",
+						Usage:     "Create an org profile",
+						ArgsUsage: "<name>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "org-id", Usage: "Organization ID", Required: true},
+							&cli.StringFlag{Name: "default-env", Usage: "Environment used when none is given"},
+							&cli.StringFlag{Name: "registry-region", Usage: "ECR registry region", Value: "us-east-1"},
+							&cli.StringSliceFlag{Name: "registry-account", Usage: "ECR registry account (repeatable), platform images first, then app images"},
+						},
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() < 1 {
+								return fmt.Errorf("missing <name>")
+							}
+
+							p := &Profile{
+								OrgID:      c.String("org-id"),
+								DefaultEnv: c.String("default-env"),
+								Registry: RegistryConfig{
+									Region:   c.String("registry-region"),
+									Accounts: c.StringSlice("registry-account"),
+								},
+							}
+							return createProfile(c.Args().Get(0), p)
+						},
+					},
+					{
+						Name:      "use",
+						Usage:     "Switch the active profile",
+						ArgsUsage: "<name>",
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() < 1 {
+								return fmt.Errorf("missing <name>")
+							}
+							return useProfile(c.Args().Get(0))
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List profiles",
+						Action: func(c *cli.Context) error {
+							return listProfiles()
+						},
+					},
+				},
+			},
+			{
+				Name:  "remote",
+				Usage: "Work with a remote DOCKER_HOST",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "tunnel",
+						Usage: "Forward the platform ports of the remote host to this machine",
+						Action: func(c *cli.Context) error {
+							return remoteTunnel()
+						},
+					},
+				},
+			},
+			{
+				Name:  "services",
+				Usage: "Inspect the services of an app",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "list",
+						Usage:     "Show the services `polycode run` registers and why",
+						ArgsUsage: "[app-path]",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "json", Usage: "Print the services and failures as JSON, same as the global --json"},
+						},
+						Action: func(c *cli.Context) error {
+							appPath := c.Args().First()
+							if appPath == "" {
+								wd, err := os.Getwd()
+								if err != nil {
+									return fmt.Errorf("failed to get current directory: %w", err)
+								}
+								appPath = wd
+							}
+							return listServices(appPath, jsonOutput(c))
+						},
+					},
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "View or change CLI settings",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "set",
+						Usage:     "Set a config value",
+						ArgsUsage: "<key> <value>",
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() < 2 {
+								return fmt.Errorf("missing <key> <value>")
+							}
+							return setConfig(c.Args().Get(0), c.Args().Get(1))
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List config values",
+						Action: func(c *cli.Context) error {
+							return listConfig()
+						},
+					},
+				},
+			},
+			{
+				Name:  "doctor",
+				Usage: "Diagnose the local setup",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "toolchain", Usage: "Report the toolchain inside each built image"},
+					&cli.BoolFlag{Name: "engines", Usage: "Report the container engines found and what each supports"},
+					&cli.BoolFlag{Name: "json", Usage: "Print the check results and failures as JSON, same as the global --json"},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Bool("engines") {
+						return engineReport()
+					}
+					if c.Bool("toolchain") {
+						return toolchainReport()
+					}
+					return runDoctor(jsonOutput(c))
+				},
+			},
+			{
+				Name:  "nats",
+				Usage: "Inspect NATS traffic on the local platform",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "server", Usage: "NATS server URL", DefaultText: "the local platform"},
+					&cli.StringFlag{Name: "monitor", Usage: "NATS monitoring URL", DefaultText: "the local platform"},
+				},
+				Subcommands: []*cli.Command{
+					{
+						Name:      "sub",
+						Usage:     "Print messages on a subject",
+						ArgsUsage: "<subject>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "raw", Usage: "Print payloads only"},
+						},
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() < 1 {
+								return fmt.Errorf("missing <subject>")
+							}
+							return natsSubscribe(natsServerURL(c), c.Args().Get(0), c.Bool("raw"))
+						},
+					},
+					{
+						Name:      "pub",
+						Usage:     "Publish a message",
+						ArgsUsage: "<subject> [data]",
+						Flags: []cli.Flag{
+							&cli.StringSliceFlag{Name: "header", Aliases: []string{"H"}, Usage: "Header as key=value (repeatable)"},
+							&cli.BoolFlag{Name: "request", Usage: "Wait for a reply and print it"},
+						},
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() < 1 {
+								return fmt.Errorf("missing <subject>")
+							}
+							return natsPublish(natsServerURL(c), c.Args().Get(0), c.Args().Get(1),
+								c.StringSlice("header"), c.Bool("request"))
+						},
+					},
+					{
+						Name:  "stats",
+						Usage: "Show server, connection and subscription stats",
+						Action: func(c *cli.Context) error {
+							return natsStats(natsMonitorURL(c))
+						},
+					},
+					{
+						Name:      "record",
+						Usage:     "Record messages matching a subject pattern to NDJSON",
+						ArgsUsage: "<subject>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "out", Aliases: []string{"o"}, Usage: "Output file", Value: "nats-recording.ndjson"},
+						},
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() < 1 {
+								return fmt.Errorf("missing <subject>")
+							}
+							return natsRecord(natsServerURL(c), c.Args().Get(0), c.String("out"))
+						},
+					},
+					{
+						Name:  "stream",
+						Usage: "Manage JetStream streams",
+						Subcommands: []*cli.Command{
+							{
+								Name:  "apply",
+								Usage: "Declare the streams and consumers from " + manifestFile,
+								Action: func(c *cli.Context) error {
+									wd, err := os.Getwd()
+									if err != nil {
+										return fmt.Errorf("failed to get current directory: %w", err)
+									}
+									manifest, err := loadManifest(wd)
+									if err != nil {
+										return err
+									}
+									return applyStreams(natsServerURL(c), manifest.Nats.Streams)
+								},
+							},
+							{
+								Name:  "ls",
+								Usage: "List streams",
+								Action: func(c *cli.Context) error {
+									return listStreams(natsServerURL(c))
+								},
+							},
+							{
+								Name:      "info",
+								Usage:     "Show a stream",
+								ArgsUsage: "<stream>",
+								Action: func(c *cli.Context) error {
+									if c.Args().Len() < 1 {
+										return fmt.Errorf("missing <stream>")
+									}
+									return streamInfo(natsServerURL(c), c.Args().Get(0))
+								},
+							},
+							{
+								Name:      "purge",
+								Usage:     "Remove all messages from a stream",
+								ArgsUsage: "<stream>",
+								Flags: []cli.Flag{
+									&cli.StringFlag{Name: "subject", Usage: "Only purge messages on this subject"},
+								},
+								Action: func(c *cli.Context) error {
+									if c.Args().Len() < 1 {
+										return fmt.Errorf("missing <stream>")
+									}
+									return purgeStream(natsServerURL(c), c.Args().Get(0), c.String("subject"))
+								},
+							},
+						},
+					},
+					{
+						Name:  "consumer",
+						Usage: "Inspect JetStream consumers",
+						Subcommands: []*cli.Command{
+							{
+								Name:      "ls",
+								Usage:     "List the consumers of a stream",
+								ArgsUsage: "<stream>",
+								Action: func(c *cli.Context) error {
+									if c.Args().Len() < 1 {
+										return fmt.Errorf("missing <stream>")
+									}
+									return listConsumers(natsServerURL(c), c.Args().Get(0))
+								},
+							},
+							{
+								Name:      "info",
+								Usage:     "Show a consumer",
+								ArgsUsage: "<stream> <consumer>",
+								Action: func(c *cli.Context) error {
+									if c.Args().Len() < 2 {
+										return fmt.Errorf("missing <stream> <consumer>")
+									}
+									return consumerInfo(natsServerURL(c), c.Args().Get(0), c.Args().Get(1))
+								},
+							},
+						},
+					},
+					{
+						Name:      "replay",
+						Usage:     "Publish a recording again",
+						ArgsUsage: "<file>",
+						Flags: []cli.Flag{
+							&cli.Float64Flag{Name: "speed", Usage: "Playback speed, 0 sends without delays", Value: 1},
+						},
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() < 1 {
+								return fmt.Errorf("missing <file>")
+							}
+							return natsReplay(natsServerURL(c), c.Args().Get(0), c.Float64("speed"))
+						},
+					},
+				},
+			},
+			{
+				Name:  "files",
+				Usage: "Browse and transfer files in the local " + filesBucket + " bucket",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "env", Usage: "Scope paths to the prefix of an environment"},
+				},
+				Subcommands: []*cli.Command{
+					{
+						Name:      "ls",
+						Usage:     "List files",
+						ArgsUsage: "[path]",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "recursive", Aliases: []string{"r"}, Usage: "List everything below path"},
+						},
+						Action: func(c *cli.Context) error {
+							store, err := newFileStore(c.Context, c.String("env"))
+							if err != nil {
+								return err
+							}
+							return store.Ls(c.Context, c.Args().Get(0), c.Bool("recursive"))
+						},
+					},
+					{
+						Name:      "cat",
+						Usage:     "Print a file",
+						ArgsUsage: "<path>",
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() < 1 {
+								return fmt.Errorf("missing <path>")
+							}
+							store, err := newFileStore(c.Context, c.String("env"))
+							if err != nil {
+								return err
+							}
+							return store.Cat(c.Context, c.Args().Get(0))
+						},
+					},
+					{
+						Name:      "cp",
+						Usage:     "Copy files to or from the bucket, prefix bucket paths with " + remotePrefix,
+						ArgsUsage: "<src> <dst>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "recursive", Aliases: []string{"r"}, Usage: "Copy folders"},
+						},
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() < 2 {
+								return fmt.Errorf("missing <src> <dst>")
+							}
+							store, err := newFileStore(c.Context, c.String("env"))
+							if err != nil {
+								return err
+							}
+							return store.Cp(c.Context, c.Args().Get(0), c.Args().Get(1), c.Bool("recursive"))
+						},
+					},
+					{
+						Name:      "rm",
+						Usage:     "Delete files",
+						ArgsUsage: "<path>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "recursive", Aliases: []string{"r"}, Usage: "Delete everything below path"},
+						},
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() < 1 {
+								return fmt.Errorf("missing <path>")
+							}
+							store, err := newFileStore(c.Context, c.String("env"))
+							if err != nil {
+								return err
+							}
+							return store.Rm(c.Context, c.Args().Get(0), c.Bool("recursive"))
+						},
+					},
+					{
+						Name:      "presign",
+						Usage:     "Print a presigned URL for a file",
+						ArgsUsage: "<path>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "put", Usage: "Presign an upload instead of a download"},
+							&cli.DurationFlag{Name: "expires", Usage: "URL lifetime", Value: 15 * time.Minute},
+						},
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() < 1 {
+								return fmt.Errorf("missing <path>")
+							}
+							store, err := newFileStore(c.Context, c.String("env"))
+							if err != nil {
+								return err
+							}
+							return store.Presign(c.Context, c.Args().Get(0), c.Bool("put"), c.Duration("expires"))
+						},
+					},
+				},
+			},
+			{
+				Name:  "app",
+				Usage: "Control an app started with `polycode run`",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "app", Usage: "App name (defaults to the current folder)"},
+				},
+				Subcommands: []*cli.Command{
+					appControlCommand("rebuild", "Rebuild and restart the app"),
+					appControlCommand("pause", "Pause watching for changes"),
+					appControlCommand("resume", "Resume watching for changes"),
+					appControlCommand("restart", "Restart the app process without rebuilding"),
+				},
+			},
 			{
 				Name:      "run",
 				Usage:     "Run an app in the given environment",
-				ArgsUsage: "<environment-id> [host-port]",
+				ArgsUsage: "[environment-id] [host-port]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "rebuild", Usage: "Rebuild the image even if nothing changed"},
+					&cli.BoolFlag{Name: "export-cache", Usage: "Export the build cache to ~/.polycode/cache"},
+					&cli.BoolFlag{Name: "delve", Usage: "Run the app under a headless Delve debugger"},
+					&cli.BoolFlag{Name: "wait-for-debugger", Usage: "With --delve, hold the app until a debugger attaches"},
+					&cli.StringFlag{Name: "sync", Usage: "How the project gets into the container: bind, copy or mutagen-like"},
+					&cli.StringFlag{Name: "project-root", Usage: "Directory mounted at /project, instead of the nearest go.work, polycode.yaml or git root"},
+				},
 				Action: func(c *cli.Context) error {
-					if c.Args().Len() < 1 {
-						return fmt.Errorf("missing <environment-id>")
+					envID, err := resolveEnvID(c.Args().Get(0))
+					if err != nil {
+						return err
 					}
 
-					envID := c.Args().Get(0)
 					hostPort := ""
 					if c.Args().Len() >= 2 {
 						hostPort = c.Args().Get(1)
@@ -957,7 +1698,19 @@ func main() {
 						return fmt.Errorf("failed to get current directory: %w", err)
 					}
 
-					if err := runApp(appPath, envID, hostPort); err != nil {
+					buildOpts := BuildOptions{
+						Force:       c.Bool("rebuild"),
+						ExportCache: c.Bool("export-cache"),
+					}
+
+					runOpts := RunOptions{
+						Debug:           c.Bool("delve") || c.Bool("wait-for-debugger"),
+						WaitForDebugger: c.Bool("wait-for-debugger"),
+						Sync:            c.String("sync"),
+						ProjectRoot:     c.String("project-root"),
+					}
+
+					if err := runApp(appPath, envID, hostPort, buildOpts, runOpts); err != nil {
 						return fmt.Errorf("run app failed: %w", err)
 					}
 
@@ -968,7 +1721,7 @@ func main() {
 	}
 
 	if err := app.Run(os.Args); err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+		printError(err, jsonErrors)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const manifestFile = "polycode.yaml"
+
+// Manifest is the optional per-app project manifest (polycode.yaml).
+type Manifest struct {
+	Runtime   Runtime   `yaml:"runtime"`
+	Toolchain Toolchain `yaml:"toolchain"`
+	Sync      struct {
+		Mode    string   `yaml:"mode"`
+		Exclude []string `yaml:"exclude"`
+	} `yaml:"sync"`
+	Nats struct {
+		Streams []StreamSpec `yaml:"streams"`
+	} `yaml:"nats"`
+}
+
+func loadManifest(appPath string) (*Manifest, error) {
+	m := &Manifest{}
+
+	path := filepath.Join(appPath, manifestFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return m, nil
+}
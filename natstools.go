@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nats-io/nats.go"
+)
+
+const natsClientName = "polycode-cli"
+
+func connectNats(url string) (*nats.Conn, error) {
+	nc, err := nats.Connect(url, nats.Name(natsClientName), nats.Timeout(5*time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS at %s (is the platform started?): %w", url, err)
+	}
+	return nc, nil
+}
+
+// interruptContext is cancelled on Ctrl-C.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// RecordedMsg is one line of a NATS recording. Payloads that are not valid
+// UTF-8 are kept in DataBase64 instead of Data.
+type RecordedMsg struct {
+	Time       time.Time           `json:"time"`
+	Subject    string              `json:"subject"`
+	Reply      string              `json:"reply,omitempty"`
+	Header     map[string][]string `json:"header,omitempty"`
+	Data       string              `json:"data,omitempty"`
+	DataBase64 string              `json:"dataBase64,omitempty"`
+}
+
+func newRecordedMsg(m *nats.Msg) RecordedMsg {
+	rec := RecordedMsg{
+		Time:    time.Now().UTC(),
+		Subject: m.Subject,
+		Reply:   m.Reply,
+		Header:  m.Header,
+	}
+	if utf8.Valid(m.Data) {
+		rec.Data = string(m.Data)
+	} else {
+		rec.DataBase64 = base64.StdEncoding.EncodeToString(m.Data)
+	}
+	return rec
+}
+
+func (r RecordedMsg) msg() (*nats.Msg, error) {
+	// The reply subject is left out, the requester is long gone and a fresh
+	// answer must not reach whoever holds the inbox now
+	m := nats.NewMsg(r.Subject)
+	for k, vs := range r.Header {
+		for _, v := range vs {
+			m.Header.Add(k, v)
+		}
+	}
+
+	m.Data = []byte(r.Data)
+	if r.DataBase64 != "" {
+		data, err := base64.StdEncoding.DecodeString(r.DataBase64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload for %s: %w", r.Subject, err)
+		}
+		m.Data = data
+	}
+	return m, nil
+}
+
+func natsSubscribe(url, subject string, raw bool) error {
+	nc, err := connectNats(url)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	_, err = nc.Subscribe(subject, func(m *nats.Msg) {
+		if raw {
+			fmt.Println(string(m.Data))
+			return
+		}
+		fmt.Printf("[%s] %s", time.Now().Format("15:04:05.000"), m.Subject)
+		if m.Reply != "" {
+			fmt.Printf(" (reply: %s)", m.Reply)
+		}
+		fmt.Println()
+		for k, vs := range m.Header {
+			fmt.Printf("  %s: %s\n", k, strings.Join(vs, ", "))
+		}
+		fmt.Printf("  %s\n", string(m.Data))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	fmt.Printf("👂 Listening on %s, Ctrl-C to stop.\n", subject)
+	<-ctx.Done()
+	return nil
+}
+
+func natsPublish(url, subject, data string, headers []string, request bool) error {
+	nc, err := connectNats(url)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	m := nats.NewMsg(subject)
+	m.Data = []byte(data)
+	for _, h := range headers {
+		k, v, ok := strings.Cut(h, "=")
+		if !ok {
+			return fmt.Errorf("invalid header '%s', expected key=value", h)
+		}
+		m.Header.Add(k, v)
+	}
+
+	if request {
+		resp, err := nc.RequestMsg(m, 5*time.Second)
+		if err != nil {
+			return fmt.Errorf("request to %s failed: %w", subject, err)
+		}
+		fmt.Println(string(resp.Data))
+		return nil
+	}
+
+	if err := nc.PublishMsg(m); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", subject, err)
+	}
+	if err := nc.Flush(); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", subject, err)
+	}
+	fmt.Printf("✅ Published %d bytes to %s.\n", len(m.Data), subject)
+	return nil
+}
+
+// natsRecord writes every message matching subject to out as NDJSON.
+func natsRecord(url, subject, out string) error {
+	nc, err := connectNats(url)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", out, err)
+	}
+	defer f.Close()
+
+	msgs := make(chan *nats.Msg, 1024)
+	sub, err := nc.ChanSubscribe(subject, msgs)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+	}
+	defer sub.Unsubscribe()
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	w := bufio.NewWriter(f)
+	defer w.Flush()
+	enc := json.NewEncoder(w)
+
+	fmt.Printf("⏺️  Recording %s to %s, Ctrl-C to stop.\n", subject, out)
+	count := 0
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Printf("✅ Recorded %d messages.\n", count)
+			return nil
+		case m := <-msgs:
+			if err := enc.Encode(newRecordedMsg(m)); err != nil {
+				return fmt.Errorf("failed to write %s: %w", out, err)
+			}
+			count++
+		}
+	}
+}
+
+// natsReplay publishes a recording again, keeping the original spacing
+// between messages scaled by speed (0 sends them back to back).
+func natsReplay(url, in string, speed float64) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", in, err)
+	}
+	defer f.Close()
+
+	nc, err := connectNats(url)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	dec := json.NewDecoder(f)
+	var prev time.Time
+	count := 0
+	for {
+		var rec RecordedMsg
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read %s: %w", in, err)
+		}
+
+		if speed > 0 && !prev.IsZero() {
+			select {
+			case <-time.After(time.Duration(float64(rec.Time.Sub(prev)) / speed)):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		prev = rec.Time
+
+		m, err := rec.msg()
+		if err != nil {
+			return err
+		}
+		if err := nc.PublishMsg(m); err != nil {
+			return fmt.Errorf("failed to publish to %s: %w", rec.Subject, err)
+		}
+		count++
+	}
+
+	if err := nc.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Replayed %d messages.\n", count)
+	return nil
+}
+
+func fetchMonitor(monitorURL, path string, v interface{}) error {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(strings.TrimRight(monitorURL, "/") + path)
+	if err != nil {
+		return fmt.Errorf("failed to reach NATS monitoring at %s: %w", monitorURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("NATS monitoring %s returned %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+func natsStats(monitorURL string) error {
+	var varz struct {
+		ServerID      string `json:"server_id"`
+		Version       string `json:"version"`
+		Uptime        string `json:"uptime"`
+		Connections   int    `json:"connections"`
+		Subscriptions int    `json:"subscriptions"`
+		InMsgs        int64  `json:"in_msgs"`
+		OutMsgs       int64  `json:"out_msgs"`
+		InBytes       int64  `json:"in_bytes"`
+		OutBytes      int64  `json:"out_bytes"`
+		SlowConsumers int64  `json:"slow_consumers"`
+	}
+	if err := fetchMonitor(monitorURL, "/varz", &varz); err != nil {
+		return err
+	}
+
+	var connz struct {
+		Connections []struct {
+			CID      uint64 `json:"cid"`
+			Name     string `json:"name"`
+			IP       string `json:"ip"`
+			Port     int    `json:"port"`
+			Pending  int    `json:"pending_bytes"`
+			InMsgs   int64  `json:"in_msgs"`
+			OutMsgs  int64  `json:"out_msgs"`
+			NumSubs  int    `json:"subscriptions"`
+			Language string `json:"lang"`
+		} `json:"connections"`
+	}
+	if err := fetchMonitor(monitorURL, "/connz", &connz); err != nil {
+		return err
+	}
+
+	var subsz struct {
+		NumSubs   int     `json:"num_subscriptions"`
+		NumCache  int     `json:"num_cache"`
+		NumMatch  int64   `json:"num_matches"`
+		CacheRate float64 `json:"cache_hit_rate"`
+	}
+	if err := fetchMonitor(monitorURL, "/subsz", &subsz); err != nil {
+		return err
+	}
+
+	fmt.Printf("Server       %s (v%s, up %s)\n", varz.ServerID, varz.Version, varz.Uptime)
+	fmt.Printf("Messages     in %d (%d bytes), out %d (%d bytes)\n", varz.InMsgs, varz.InBytes, varz.OutMsgs, varz.OutBytes)
+	fmt.Printf("Subs         %d (matches %d, cache hit rate %.2f)\n", subsz.NumSubs, subsz.NumMatch, subsz.CacheRate)
+	fmt.Printf("Slow         %d slow consumers\n", varz.SlowConsumers)
+	fmt.Printf("Connections  %d\n", varz.Connections)
+
+	conns := connz.Connections
+	sort.Slice(conns, func(i, j int) bool { return conns[i].CID < conns[j].CID })
+	for _, c := range conns {
+		name := c.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Printf("  #%-4d %-24s %s:%d %-6s subs=%d in=%d out=%d pending=%d\n",
+			c.CID, name, c.IP, c.Port, c.Language, c.NumSubs, c.InMsgs, c.OutMsgs, c.Pending)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// hostPlatform returns the platform containers run natively on. The
+// container engine is asked first since the CLI binary itself may run under
+// emulation (e.g. Rosetta), and runtime.GOARCH is used when the engine is
+// unreachable.
+func hostPlatform() string {
+	arch := runtime.GOARCH
+	if a, err := containerEngine().Arch(); err == nil && a != "" {
+		arch = a
+	}
+	return "linux/" + normalizeArch(arch)
+}
+
+func normalizeArch(arch string) string {
+	switch arch {
+	case "x86_64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	}
+	return arch
+}
+
+// imagePlatforms returns the platforms image is published for, or nil when
+// they cannot be determined.
+func imagePlatforms(image string) ([]string, error) {
+	out, err := containerEngine().RawManifest(image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect %s: %w", image, err)
+	}
+
+	var index struct {
+		Manifests []struct {
+			Platform *struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}
+	if err := json.Unmarshal(out, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of %s: %w", image, err)
+	}
+
+	if len(index.Manifests) == 0 {
+		// Single-platform image, read the platform from its config (docker only)
+		out, err := cmdOutput(containerEngine().Command("buildx", "imagetools", "inspect",
+			"--format", "{{.Image.OS}}/{{.Image.Architecture}}", image))
+		if err != nil {
+			return nil, nil
+		}
+		return []string{strings.TrimSpace(string(out))}, nil
+	}
+
+	var platforms []string
+	for _, m := range index.Manifests {
+		// Attestation manifests carry an "unknown" platform
+		if m.Platform == nil || m.Platform.OS == "unknown" {
+			continue
+		}
+		platforms = append(platforms, m.Platform.OS+"/"+m.Platform.Architecture)
+	}
+	return platforms, nil
+}
+
+// supportsPlatform reports whether image has a variant for platform. Images
+// that cannot be inspected are assumed to support it.
+func supportsPlatform(image, platform string) bool {
+	platforms, err := imagePlatforms(image)
+	if err != nil || len(platforms) == 0 {
+		return true
+	}
+	for _, p := range platforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// buildPlatform picks the platform to build the dev image for: the native
+// one, unless one of the images the Dockerfile builds from has no variant
+// for it.
+func buildPlatform(images ...string) string {
+	native := hostPlatform()
+	platform := native
+	checked := map[string]bool{}
+	for _, image := range images {
+		if checked[image] {
+			continue
+		}
+		checked[image] = true
+		if !supportsPlatform(image, native) {
+			fmt.Printf("⚠️  %s has no %s variant, building for linux/amd64 under emulation.\n", image, native)
+			platform = "linux/amd64"
+		}
+	}
+
+	if arch, err := sidecarArch(); err == nil && "linux/"+arch != platform {
+		fmt.Printf("⚠️  The sidecar is only published for linux/%s, it runs under emulation in a %s container.\n", arch, platform)
+	}
+	return platform
+}
+
+// sidecarArch reads the architecture of the downloaded sidecar binary,
+// which is published for a single platform.
+func sidecarArch() (string, error) {
+	f, err := elf.Open(filepath.Join(getPolycodeDir(), "runtime", "sidecar"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	switch f.Machine {
+	case elf.EM_X86_64:
+		return "amd64", nil
+	case elf.EM_AARCH64:
+		return "arm64", nil
+	}
+	return "", fmt.Errorf("unknown sidecar architecture %s", f.Machine)
+}
+
+// composePlatformEnv checks the images of a compose stack against the native
+// platform. It returns DOCKER_DEFAULT_PLATFORM when every image supports it;
+// otherwise it warns and lets docker pick a variant per image. env is what
+// the images in the compose file are interpolated with.
+func composePlatformEnv(composeFile string, env ...string) []string {
+	native := hostPlatform()
+
+	cmd := containerEngine().Compose("-f", composeFile, "config", "--images")
+	cmd.Dir = getPolycodeDir()
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmdOutput(cmd)
+	if err != nil {
+		return []string{"DOCKER_DEFAULT_PLATFORM=" + native}
+	}
+
+	allNative := true
+	for _, image := range strings.Fields(string(out)) {
+		if !supportsPlatform(image, native) {
+			fmt.Printf("⚠️  %s has no %s variant, it will run under emulation.\n", image, native)
+			allNative = false
+		}
+	}
+
+	if !allNative {
+		return nil
+	}
+	return []string{"DOCKER_DEFAULT_PLATFORM=" + native}
+}
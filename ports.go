@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PortConfig holds the host ports of the platform stack. Zero keeps the
+// default.
+type PortConfig struct {
+	DynamoDB    int `json:"dynamodb,omitempty"`
+	S3          int `json:"s3,omitempty"`
+	Console     int `json:"console,omitempty"`
+	Nats        int `json:"nats,omitempty"`
+	NatsMonitor int `json:"natsMonitor,omitempty"`
+	// Debug is the host port of the debugger, the runtime's port by default.
+	Debug int `json:"debug,omitempty"`
+}
+
+func orDefault(port, def int) int {
+	if port == 0 {
+		return def
+	}
+	return port
+}
+
+// withDefaults fills in the ports that were not remapped.
+func (p PortConfig) withDefaults() PortConfig {
+	p.DynamoDB = orDefault(p.DynamoDB, 8000)
+	p.S3 = orDefault(p.S3, 9000)
+	p.Console = orDefault(p.Console, 9001)
+	p.Nats = orDefault(p.Nats, 4222)
+	p.NatsMonitor = orDefault(p.NatsMonitor, 8222)
+	return p
+}
+
+// platformPorts returns the configured host ports, falling back to the
+// defaults when the config cannot be read.
+func platformPorts() PortConfig {
+	cfg, err := loadConfig()
+	if err != nil {
+		return PortConfig{}.withDefaults()
+	}
+	return cfg.Ports.withDefaults()
+}
+
+type portUse struct {
+	Port    int
+	Service string
+	Key     string
+}
+
+// uses lists the platform ports with the service using them and the config
+// key that remaps them.
+func (p PortConfig) uses() []portUse {
+	return []portUse{
+		{p.DynamoDB, "DynamoDB", "ports.dynamodb"},
+		{p.S3, "MinIO", "ports.s3"},
+		{p.Console, "MinIO console", "ports.console"},
+		{p.Nats, "NATS", "ports.nats"},
+		{p.NatsMonitor, "NATS monitoring", "ports.natsMonitor"},
+	}
+}
+
+// composeEnv returns the variables the platform compose file publishes its
+// ports with.
+func (p PortConfig) composeEnv() []string {
+	return []string{
+		fmt.Sprintf("POLYCODE_DYNAMODB_PORT=%d", p.DynamoDB),
+		fmt.Sprintf("POLYCODE_S3_PORT=%d", p.S3),
+		fmt.Sprintf("POLYCODE_S3_CONSOLE_PORT=%d", p.Console),
+		fmt.Sprintf("POLYCODE_NATS_PORT=%d", p.Nats),
+		fmt.Sprintf("POLYCODE_NATS_MONITOR_PORT=%d", p.NatsMonitor),
+	}
+}
+
+// appEnv returns the host-side ports for app containers, which reach the
+// services by name inside polycode-dev but may hand out URLs to the host
+// (e.g. presigned S3 URLs).
+func (p PortConfig) appEnv() []string {
+	return []string{
+		fmt.Sprintf("polycode_DYNAMODB_PORT=%d", p.DynamoDB),
+		fmt.Sprintf("polycode_S3_PORT=%d", p.S3),
+		fmt.Sprintf("polycode_NATS_PORT=%d", p.Nats),
+	}
+}
+
+func (p PortConfig) dynamoEndpoint() string {
+	return fmt.Sprintf("http://%s:%d/", platformHost(), p.DynamoDB)
+}
+
+func (p PortConfig) s3Endpoint() string {
+	return fmt.Sprintf("http://%s:%d/", platformHost(), p.S3)
+}
+
+func (p PortConfig) natsURL() string {
+	return fmt.Sprintf("nats://%s:%d", platformHost(), p.Nats)
+}
+
+func (p PortConfig) monitorURL() string {
+	return fmt.Sprintf("http://%s:%d", platformHost(), p.NatsMonitor)
+}
+
+func (p PortConfig) consoleURL() string {
+	return fmt.Sprintf("http://%s:%d", platformHost(), p.Console)
+}
+
+// portInUse reports whether something holds port on the host. A listener on
+// 127.0.0.1 alone does not always stop a wildcard bind (macOS allows both),
+// so the loopback address is bound and dialled as well.
+func portInUse(port int) bool {
+	p := strconv.Itoa(port)
+	for _, addr := range []string{":" + p, "127.0.0.1:" + p} {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return true
+		}
+		l.Close()
+	}
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:"+p, 200*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// platformPublishedPorts returns the host ports the running platform holds.
+func platformPublishedPorts() map[int]bool {
+	ports := map[int]bool{}
+	services, err := projectStatus("polycode-platform", "docker-compose-platform.yml", nil)
+	if err != nil {
+		return ports
+	}
+	for _, svc := range services {
+		for _, p := range svc.Publishers {
+			ports[p.PublishedPort] = true
+		}
+	}
+	return ports
+}
+
+// checkPortConflicts fails when a platform port is taken by something other
+// than the platform itself, so we never start next to (and talk to) another
+// local DynamoDB or MinIO.
+func checkPortConflicts(ports PortConfig) error {
+	ours := platformPublishedPorts()
+
+	var conflicts []string
+	seen := map[int]bool{}
+	for _, u := range ports.uses() {
+		if seen[u.Port] {
+			return fmt.Errorf("port %d is configured for more than one service, check `polycode config list`", u.Port)
+		}
+		seen[u.Port] = true
+
+		if !ours[u.Port] && portInUse(u.Port) {
+			conflicts = append(conflicts, fmt.Sprintf("  %d (%s) is in use, remap it with `polycode config set %s <port>`", u.Port, u.Service, u.Key))
+		}
+	}
+	if len(conflicts) > 0 {
+		return newCLIError(ErrPortInUse, fmt.Errorf("platform ports are taken by other processes:\n%s", strings.Join(conflicts, "\n"))).
+			withHint("Stop the processes holding the ports, or remap them as shown above.")
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const defaultOrgID = "xxx"
+
+// RegistryConfig is the ECR registry images are pulled from. The first
+// account holds the platform images (next-env and the Go builder), the
+// second the shared app images; a single account serves both.
+type RegistryConfig struct {
+	Region   string   `json:"region"`
+	Accounts []string `json:"accounts"`
+}
+
+func ecrHost(account, region string) string {
+	return fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", account, region)
+}
+
+func (r RegistryConfig) registry(i int) string {
+	if len(r.Accounts) == 0 {
+		return ""
+	}
+	return ecrHost(r.Accounts[min(i, len(r.Accounts)-1)], r.Region)
+}
+
+func (r RegistryConfig) platformRegistry() string { return r.registry(0) }
+func (r RegistryConfig) appsRegistry() string     { return r.registry(1) }
+
+type Profile struct {
+	OrgID      string         `json:"orgId"`
+	DefaultEnv string         `json:"defaultEnv,omitempty"`
+	Registry   RegistryConfig `json:"registry"`
+}
+
+type ProfileStore struct {
+	Active   string              `json:"active,omitempty"`
+	Profiles map[string]*Profile `json:"profiles"`
+}
+
+func defaultProfile() *Profile {
+	return &Profile{
+		OrgID: defaultOrgID,
+		Registry: RegistryConfig{
+			Region:   "us-east-1",
+			Accounts: ecrAccounts,
+		},
+	}
+}
+
+func profilesPath() string {
+	return filepath.Join(getPolycodeDir(), "profiles.json")
+}
+
+func loadProfiles() (*ProfileStore, error) {
+	store := &ProfileStore{Profiles: map[string]*Profile{}}
+
+	data, err := os.ReadFile(profilesPath())
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles: %w", err)
+	}
+
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("failed to parse profiles: %w", err)
+	}
+	if store.Profiles == nil {
+		store.Profiles = map[string]*Profile{}
+	}
+	return store, nil
+}
+
+func saveProfiles(store *ProfileStore) error {
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode profiles: %w", err)
+	}
+	if err := os.WriteFile(profilesPath(), data, 0644); err != nil {
+		return fmt.Errorf("failed to write profiles: %w", err)
+	}
+	return nil
+}
+
+// activeProfile returns the profile selected with `polycode profile use`,
+// falling back to the built-in defaults when none is selected.
+func activeProfile() (*Profile, error) {
+	store, err := loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	if store.Active == "" {
+		return defaultProfile(), nil
+	}
+
+	p, ok := store.Profiles[store.Active]
+	if !ok {
+		return nil, fmt.Errorf("active profile '%s' does not exist", store.Active)
+	}
+
+	// Fill in anything left empty at creation time
+	def := defaultProfile()
+	if p.OrgID == "" {
+		p.OrgID = def.OrgID
+	}
+	if p.Registry.Region == "" {
+		p.Registry.Region = def.Registry.Region
+	}
+	if len(p.Registry.Accounts) == 0 {
+		p.Registry.Accounts = def.Registry.Accounts
+	}
+	return p, nil
+}
+
+// profileEnv returns the environment variables passed to docker compose so
+// the env stack runs under the active profile's org and pulls from its
+// registry.
+func profileEnv(p *Profile) []string {
+	return []string{
+		"polycode_ORG_ID=" + p.OrgID,
+		"POLYCODE_PLATFORM_REGISTRY=" + p.Registry.platformRegistry(),
+		"POLYCODE_APPS_REGISTRY=" + p.Registry.appsRegistry(),
+	}
+}
+
+// resolveEnvID returns envID, or the active profile's default environment
+// when envID is empty.
+func resolveEnvID(envID string) (string, error) {
+	if envID != "" {
+		return envID, nil
+	}
+
+	p, err := activeProfile()
+	if err != nil {
+		return "", err
+	}
+	if p.DefaultEnv == "" {
+		return "", fmt.Errorf("missing <environment-id> and the active profile has no default environment")
+	}
+	return p.DefaultEnv, nil
+}
+
+func createProfile(name string, p *Profile) error {
+	if name == "" {
+		return fmt.Errorf("profile name is required")
+	}
+
+	store, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	if _, exists := store.Profiles[name]; exists {
+		return fmt.Errorf("profile '%s' already exists", name)
+	}
+
+	store.Profiles[name] = p
+	if store.Active == "" {
+		store.Active = name
+	}
+
+	if err := saveProfiles(store); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Profile %s created.\n", name)
+	return nil
+}
+
+func useProfile(name string) error {
+	store, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	if _, ok := store.Profiles[name]; !ok {
+		return fmt.Errorf("profile '%s' does not exist", name)
+	}
+
+	store.Active = name
+	if err := saveProfiles(store); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Using profile %s.\n", name)
+	return nil
+}
+
+func listProfiles() error {
+	store, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+
+	if len(store.Profiles) == 0 {
+		fmt.Println("No profiles. Using defaults (org " + defaultOrgID + ").")
+		return nil
+	}
+
+	names := make([]string, 0, len(store.Profiles))
+	for name := range store.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := store.Profiles[name]
+		marker := " "
+		if name == store.Active {
+			marker = "*"
+		}
+		fmt.Printf("%s %-16s org=%s env=%s registry=%s\n",
+			marker, name, p.OrgID, p.DefaultEnv, p.Registry.Region)
+	}
+	return nil
+}
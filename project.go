@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// workspaceFile marks a Go workspace, whose root is the project root of
+// every module in it.
+const workspaceFile = "go.work"
+
+// findProjectRoot returns the directory mounted at /project for the app in
+// appDir, and how it was found. In order it takes override (--project-root),
+// the nearest go.work, the nearest polycode.yaml above the app, the git
+// root, and finally the app itself when it has a polycode.yaml. Markers are
+// only looked for up to the git root, so a stray go.work further up does not
+// pull in a huge tree.
+func findProjectRoot(appDir, override string) (string, string, error) {
+	appDir, err := filepath.EvalSymlinks(appDir)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve app folder: %w", err)
+	}
+
+	if override != "" {
+		root, err := filepath.Abs(override)
+		if err == nil {
+			root, err = filepath.EvalSymlinks(root)
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("invalid project root '%s': %w", override, err)
+		}
+		if !isWithin(root, appDir) {
+			return "", "", fmt.Errorf("app folder %s is not inside project root %s", appDir, root)
+		}
+		return root, "--project-root", nil
+	}
+
+	gitRoot, gitErr := getGitRoot(appDir)
+	if gitErr == nil {
+		gitRoot, gitErr = filepath.EvalSymlinks(gitRoot)
+	}
+	stop := gitRoot
+	if gitErr != nil {
+		stop = searchBoundary(appDir)
+	}
+
+	if root, ok := findUp(appDir, stop, workspaceFile); ok {
+		return root, workspaceFile, nil
+	}
+	// An app's own polycode.yaml is its manifest, only one further up marks
+	// the project
+	if appDir != stop {
+		if root, ok := findUp(filepath.Dir(appDir), stop, manifestFile); ok {
+			return root, manifestFile, nil
+		}
+	}
+	if gitErr == nil {
+		return gitRoot, "git", nil
+	}
+	if _, err := os.Stat(filepath.Join(appDir, manifestFile)); err == nil {
+		return appDir, "app folder", nil
+	}
+	return "", "", newCLIError(ErrNotAGitRepo, fmt.Errorf("no project root found for %s: %w", appDir, gitErr))
+}
+
+// searchBoundary is where marker lookups stop outside a git repository: the
+// folder right below the home directory that holds dir, so files in the
+// home directory itself are never taken for markers.
+func searchBoundary(dir string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if home, err = filepath.EvalSymlinks(home); err != nil || !isWithin(home, dir) || dir == home {
+		return ""
+	}
+	rel, _ := filepath.Rel(home, dir)
+	return filepath.Join(home, strings.Split(rel, string(filepath.Separator))[0])
+}
+
+// findUp returns the nearest directory from dir upwards that holds name,
+// looking no further than stop (the filesystem root when empty).
+func findUp(dir, stop, name string) (string, bool) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if dir == stop || parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// appFolderIn returns the path of the app relative to the project root as
+// the image sees it (APP_FOLDER), "." when the app is the root.
+func appFolderIn(root, appDir string) (string, error) {
+	appDir, err := filepath.EvalSymlinks(appDir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, appDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
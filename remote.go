@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RemoteHost is the machine containers run on when DOCKER_HOST points away
+// from the laptop (ssh:// or tcp://).
+type RemoteHost struct {
+	scheme string
+	// host is the address the platform ports are published on.
+	host string
+	// target and port are what ssh connects to.
+	target string
+	port   string
+}
+
+// remoteHost returns the remote engine host, or nil when containers run
+// locally.
+func remoteHost() *RemoteHost {
+	u, err := url.Parse(os.Getenv("DOCKER_HOST"))
+	if err != nil || (u.Scheme != "ssh" && u.Scheme != "tcp") {
+		return nil
+	}
+
+	r := &RemoteHost{scheme: u.Scheme, host: u.Hostname(), target: u.Host}
+	if u.Scheme == "ssh" {
+		r.target = u.Hostname()
+		if u.User != nil {
+			r.target = u.User.Username() + "@" + r.target
+		}
+		r.port = u.Port()
+	}
+	return r
+}
+
+func (r *RemoteHost) String() string {
+	return r.host
+}
+
+// ssh runs command on the remote host, with opts passed to ssh itself.
+func (r *RemoteHost) ssh(opts []string, command ...string) *exec.Cmd {
+	args := []string{"-o", "BatchMode=yes"}
+	if r.port != "" {
+		args = append(args, "-p", r.port)
+	}
+	args = append(args, opts...)
+	args = append(args, r.target)
+	return exec.Command("ssh", append(args, command...)...)
+}
+
+// canSSH reports whether files and ports can be moved over ssh; a tcp://
+// engine gives no way to reach the machine itself.
+func (r *RemoteHost) canSSH() bool {
+	return r.scheme == "ssh"
+}
+
+// platformHost is the host the platform ports are reached on: the remote
+// host itself, or localhost when they are tunnelled with `polycode remote
+// tunnel` (remote.tunnel true).
+func platformHost() string {
+	r := remoteHost()
+	if r == nil {
+		return "localhost"
+	}
+	if cfg, err := loadConfig(); err == nil && cfg.Remote.Tunnel {
+		return "localhost"
+	}
+	return r.host
+}
+
+// pushDir mirrors a local folder to the same absolute path on the remote
+// host, where compose bind mounts expect it.
+func (r *RemoteHost) pushDir(dir string) error {
+	if !r.canSSH() {
+		return fmt.Errorf("cannot copy %s to %s, DOCKER_HOST must use ssh:// for that", dir, r.host)
+	}
+
+	tarCmd := exec.Command("tar", "-C", dir, "-cf", "-", ".")
+	sshCmd := r.ssh(nil, fmt.Sprintf("mkdir -p %q && tar -C %q -xf -", dir, dir))
+
+	pipe, err := tarCmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	sshCmd.Stdin = pipe
+	sshCmd.Stderr = os.Stderr
+
+	traceCommand(sshCmd)
+	if err := sshCmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ssh to %s: %w", r.target, err)
+	}
+	if err := runCmd(tarCmd); err != nil {
+		return fmt.Errorf("failed to pack %s: %w", dir, err)
+	}
+	if err := sshCmd.Wait(); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", dir, r.target, err)
+	}
+	return nil
+}
+
+// forward opens an ssh session that makes the given remote ports reachable
+// on the laptop and the given laptop ports reachable from containers on the
+// remote host. Reverse forwards bind on all interfaces of the remote host,
+// which needs `GatewayPorts clientspecified` in its sshd_config.
+func (r *RemoteHost) forward(local, reverse []int) (stop func(), err error) {
+	if !r.canSSH() {
+		return nil, fmt.Errorf("cannot forward ports from %s, DOCKER_HOST must use ssh:// for that", r.host)
+	}
+
+	args := []string{"-N", "-o", "ExitOnForwardFailure=yes"}
+	for _, p := range local {
+		args = append(args, "-L", fmt.Sprintf("%d:localhost:%d", p, p))
+	}
+	for _, p := range reverse {
+		args = append(args, "-R", fmt.Sprintf("0.0.0.0:%d:localhost:%d", p, p))
+	}
+
+	cmd := r.ssh(args)
+	cmd.Stderr = os.Stderr
+	traceCommand(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ssh to %s: %w", r.target, err)
+	}
+
+	// ExitOnForwardFailure makes ssh quit right away when a port is taken
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		return nil, fmt.Errorf("port forwarding to %s failed: %v", r.target, err)
+	case <-time.After(time.Second):
+	}
+
+	return func() {
+		cmd.Process.Kill()
+		<-exited
+	}, nil
+}
+
+func joinPorts(ports []int) string {
+	s := make([]string, len(ports))
+	for i, p := range ports {
+		s[i] = strconv.Itoa(p)
+	}
+	return strings.Join(s, ", ")
+}
+
+// remoteTunnel forwards the platform ports of the remote host to the laptop
+// until Ctrl-C.
+func remoteTunnel() error {
+	r := remoteHost()
+	if r == nil {
+		return fmt.Errorf("DOCKER_HOST does not point to a remote host")
+	}
+
+	var ports []int
+	for _, u := range platformPorts().uses() {
+		ports = append(ports, u.Port)
+	}
+
+	stop, err := r.forward(ports, nil)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	fmt.Printf("🔌 Forwarding %s from %s, Ctrl-C to stop.\n", joinPorts(ports), r)
+	if cfg, err := loadConfig(); err == nil && !cfg.Remote.Tunnel {
+		fmt.Println("   Run `polycode config set remote.tunnel true` so the CLI uses the tunnel.")
+	}
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,97 @@
+#!/bin/sh
+
+# Control channel between the dev container and `polycode run`.
+# Build events are posted to the CLI over HTTP, and commands from the CLI
+# (rebuild, pause, resume, restart) are picked up by long-polling it.
+
+if [ -z "$CONTROL_URL" ] || ! command -v curl >/dev/null 2>&1; then
+  CONTROL_URL=""
+fi
+
+post() {
+  [ -n "$CONTROL_URL" ] || return 0
+  curl -fsS -m 5 -X POST \
+    -H "Authorization: Bearer $CONTROL_TOKEN" \
+    -H "Content-Type: application/json" \
+    --data-binary "$1" "$CONTROL_URL/events" >/dev/null 2>&1 || true
+}
+
+# Turns stdin into the contents of a JSON string
+json_escape() {
+  tr -d '\r' | awk 'BEGIN { ORS = "" } { gsub(/\\/, "&&"); gsub(/"/, "\\\""); gsub(/\t/, "\\t"); print $0 "\\n" }'
+}
+
+watcher_pid() {
+  cat /tmp/watcher.pid 2>/dev/null
+}
+
+stop_app() {
+  if [ -f /tmp/app.pid ]; then
+    kill -TERM -- "-$(cat /tmp/app.pid)" 2>/dev/null || true
+  fi
+}
+
+[ -n "$APP_DIR" ] && cd "$APP_DIR"
+
+case "$1" in
+  build)
+    post '{"type":"build-start"}'
+    status=0
+    sh -c "$WATCH_BUILD" > /tmp/build.log 2>&1 || status=$?
+    cat /tmp/build.log
+    if [ "$status" -eq 0 ]; then
+      post '{"type":"build-success"}'
+    else
+      post "{\"type\":\"build-failure\",\"output\":\"$(tail -n 40 /tmp/build.log | json_escape)\"}"
+    fi
+    exit "$status"
+    ;;
+
+  run)
+    # The app gets its own process group so restarts take its children along
+    trap 'stop_app; exit 0' TERM INT
+    while :; do
+      rm -f /tmp/app-restart
+      setsid sh -c "exec $WATCH_RUN" &
+      echo $! > /tmp/app.pid
+      post '{"type":"app-start"}'
+
+      status=0
+      wait $! || status=$?
+      [ -f /tmp/app-restart ] || exit "$status"
+    done
+    ;;
+
+  poll)
+    [ -n "$CONTROL_URL" ] || exit 0
+    while :; do
+      cmd=$(curl -fsS -m 40 -H "Authorization: Bearer $CONTROL_TOKEN" "$CONTROL_URL/commands?wait=30") || {
+        sleep 2
+        continue
+      }
+      case "$cmd" in
+        rebuild)
+          touch /tmp/watcher-restart
+          stop_app
+          kill -CONT "$(watcher_pid)" 2>/dev/null || true
+          kill -TERM "$(watcher_pid)" 2>/dev/null || true
+          ;;
+        pause)
+          kill -STOP "$(watcher_pid)" 2>/dev/null && post '{"type":"paused"}'
+          ;;
+        resume)
+          kill -CONT "$(watcher_pid)" 2>/dev/null && post '{"type":"resumed"}'
+          ;;
+        restart)
+          touch /tmp/app-restart
+          stop_app
+          ;;
+      esac
+    done
+    ;;
+
+  *)
+    echo "usage: $0 build|run|poll" >&2
+    exit 2
+    ;;
+esac
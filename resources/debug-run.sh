@@ -0,0 +1,25 @@
+#!/bin/sh
+set -e
+
+# poly-watcher runs this instead of the app when `polycode run --delve` is used.
+# Delve is started once, detached from poly-watcher, and every later build
+# restarts the target inside the same Delve server so debuggers stay attached.
+
+PIDFILE=/tmp/dlv.pid
+PORT="${DEBUG_PORT:-2345}"
+
+if [ -f "$PIDFILE" ] && kill -0 "$(cat "$PIDFILE")" 2>/dev/null; then
+  echo "Restarting app in debugger..."
+  printf 'restart\nexit -c\n' | dlv connect "127.0.0.1:$PORT" >/dev/null
+else
+  CONTINUE="--continue"
+  if [ "$DEBUG_WAIT" = "true" ]; then
+    echo "Waiting for debugger on port $PORT..."
+    CONTINUE=""
+  fi
+  setsid dlv exec /main --headless --accept-multiclient --api-version=2 --listen=":$PORT" $CONTINUE &
+  echo $! > "$PIDFILE"
+fi
+
+# Stay alive so poly-watcher treats the app as running
+exec tail -f /dev/null
@@ -1,6 +1,14 @@
 #!/bin/sh
 set -e
 
+# With host-side sync the CLI pushes the project in after start; wait for it
+# and let the CLI decide when to rebuild.
+if [ -n "$SYNC_MODE" ] && [ "$SYNC_MODE" != "bind" ]; then
+  echo "Waiting for project sync..."
+  while [ ! -f /tmp/sync-ready ]; do sleep 0.2; done
+  WATCH_INCLUDE=".rebuild-trigger"
+fi
+
 # Install user defined additional libraries
 if [ -f "./install.sh" ]; then \
   chmod +x ./install.sh
@@ -11,4 +19,31 @@ fi && \
 
 /tmp/sidecar &
 
-poly-watcher --depfile=go.mod --depcommand="go mod tidy && go mod download" --build="next-gen && GOOS=linux GOARCH=amd64 go build -o /main ." --run="/main" --include=.go,go.mod --exclude=.git,.polycode
+# control.sh builds and runs from the app folder, wherever poly-watcher runs
+export APP_DIR="$PWD"
+
+# The runtime settings are passed in by `polycode run`; the defaults keep the Go workflow
+export WATCH_BUILD="${WATCH_BUILD:-next-gen && GOOS=linux go build -o /main .}"
+export WATCH_RUN="${WATCH_RUN:-/main}"
+
+# Pick up rebuild/pause/restart commands from `polycode run`
+/tmp/control.sh poll &
+
+# Build and run go through control.sh so the CLI sees every build. A rebuild
+# requested by the CLI restarts poly-watcher, which builds from scratch.
+# WATCH_ROOT (a go.work directory) widens the watch to every module.
+while :; do
+  cd "${WATCH_ROOT:-$APP_DIR}"
+  poly-watcher \
+    --depfile="${WATCH_DEPFILE:-go.mod}" \
+    --depcommand="${WATCH_DEPCOMMAND:-go mod tidy && go mod download}" \
+    --build="/tmp/control.sh build" \
+    --run="/tmp/control.sh run" \
+    --include="${WATCH_INCLUDE:-.go,go.mod}" \
+    --exclude=.git,.polycode &
+  echo $! > /tmp/watcher.pid
+
+  wait $! || true
+  [ -f /tmp/watcher-restart ] || break
+  rm -f /tmp/watcher-restart
+done
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Runtime describes how the dev container watches, builds and runs an app in
+// a given language. The fields are handed to poly-watcher by entrypoint.sh.
+type Runtime struct {
+	Name string `yaml:"name"`
+	// BaseImage is the default image for the runtime, the profile's builder
+	// image when empty; override it through
+	// toolchain.baseImage in the manifest.
+	BaseImage  string   `yaml:"-"`
+	Include    []string `yaml:"include"`
+	DepFile    string   `yaml:"depFile"`
+	DepCommand string   `yaml:"depCommand"`
+	Build      string   `yaml:"build"`
+	Run        string   `yaml:"run"`
+	// Entry is the script or package the run command starts, substituted
+	// for {entry} in it.
+	Entry     string `yaml:"entry"`
+	DebugPort int    `yaml:"debugPort"`
+	// WatchRoot is where poly-watcher watches from when it is not the app
+	// folder, e.g. the directory of a go.work.
+	WatchRoot string `yaml:"-"`
+	// Env is passed to the app container as is.
+	Env map[string]string `yaml:"env"`
+}
+
+var runtimes = map[string]Runtime{
+	"go": {
+		Name:       "go",
+		Include:    []string{".go", "go.mod"},
+		DepFile:    "go.mod",
+		DepCommand: "go mod tidy && go mod download",
+		Build:      "next-gen && GOOS=linux go build -o /main .",
+		Run:        "/main",
+		DebugPort:  2345,
+	},
+	"node": {
+		Name:       "node",
+		BaseImage:  "node:20-bookworm",
+		Include:    []string{".js", ".mjs", ".cjs", ".ts", ".json"},
+		DepFile:    "package.json",
+		DepCommand: "npm install",
+		Build:      "npm run build --if-present",
+		// The inspector goes on the app's node only; in NODE_OPTIONS npm
+		// and the build would take the port first
+		Run:       "node --inspect=0.0.0.0:9229 {entry}",
+		Entry:     ".",
+		DebugPort: 9229,
+	},
+	"python": {
+		Name:       "python",
+		BaseImage:  "python:3.12-bookworm",
+		Include:    []string{".py", "pyproject.toml"},
+		DepFile:    "pyproject.toml",
+		DepCommand: "pip install debugpy && pip install -e .",
+		Build:      "true",
+		Run:        "python -m debugpy --listen 0.0.0.0:5678 {entry}",
+		Entry:      "main.py",
+		DebugPort:  5678,
+	},
+}
+
+// UnmarshalYAML also accepts the short form `runtime: node`.
+func (r *Runtime) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		r.Name = value.Value
+		return nil
+	}
+	type plain Runtime
+	return value.Decode((*plain)(r))
+}
+
+// runtimeMarkers maps the file that identifies a project to its runtime, in
+// detection order.
+var runtimeMarkers = []struct {
+	File    string
+	Runtime string
+}{
+	{"go.mod", "go"},
+	{"package.json", "node"},
+	{"pyproject.toml", "python"},
+}
+
+func detectRuntime(appPath string) string {
+	for _, m := range runtimeMarkers {
+		if _, err := os.Stat(filepath.Join(appPath, m.File)); err == nil {
+			return m.Runtime
+		}
+	}
+	return "go"
+}
+
+// resolveRuntime picks the runtime named in the manifest, or detects it from
+// the app folder, and applies any overrides from the manifest.
+func resolveRuntime(appPath string, manifest *Manifest) (Runtime, error) {
+	name := manifest.Runtime.Name
+	if name == "" {
+		name = detectRuntime(appPath)
+	}
+
+	rt, ok := runtimes[name]
+	if !ok {
+		return Runtime{}, fmt.Errorf("unsupported runtime '%s'", name)
+	}
+
+	o := manifest.Runtime
+	rt.DepFile = firstNonEmpty(o.DepFile, rt.DepFile)
+	rt.DepCommand = firstNonEmpty(o.DepCommand, rt.DepCommand)
+	rt.Build = firstNonEmpty(o.Build, rt.Build)
+	rt.Run = firstNonEmpty(o.Run, rt.Run)
+	rt.Entry = firstNonEmpty(o.Entry, rt.Entry)
+	rt.Run = strings.ReplaceAll(rt.Run, "{entry}", rt.Entry)
+	if len(o.Include) > 0 {
+		rt.Include = o.Include
+	}
+	if o.DebugPort != 0 {
+		rt.DebugPort = o.DebugPort
+	}
+	if len(o.Env) > 0 {
+		env := map[string]string{}
+		for k, v := range rt.Env {
+			env[k] = v
+		}
+		for k, v := range o.Env {
+			env[k] = v
+		}
+		rt.Env = env
+	}
+	return rt, nil
+}
+
+// containerEnv returns the variables entrypoint.sh uses to drive poly-watcher.
+func (r Runtime) containerEnv() []string {
+	env := []string{
+		"WATCH_DEPFILE=" + r.DepFile,
+		"WATCH_DEPCOMMAND=" + r.DepCommand,
+		"WATCH_BUILD=" + r.Build,
+		"WATCH_RUN=" + r.Run,
+		"WATCH_INCLUDE=" + strings.Join(r.Include, ","),
+		"DEBUG_PORT=" + strconv.Itoa(r.DebugPort),
+	}
+	if r.WatchRoot != "" {
+		env = append(env, "WATCH_ROOT="+r.WatchRoot)
+	}
+	keys := make([]string, 0, len(r.Env))
+	for k := range r.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+r.Env[k])
+	}
+	return env
+}
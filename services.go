@@ -0,0 +1,365 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// serviceFile marks a folder under services/ as a service and carries
+	// its metadata.
+	serviceFile = "service.yaml"
+	// serviceDirective marks a package as a service, optionally with its
+	// ID: //polycode:service orders (#polycode:service in Python)
+	serviceDirective = "//polycode:service"
+)
+
+// sourceRules says how a runtime's source files mark a service.
+type sourceRules struct {
+	exts      []string
+	directive string
+	// handler matches an exported function taking the service context as
+	// its first argument
+	handler *regexp.Regexp
+}
+
+var scriptRules = map[string]sourceRules{
+	"node": {
+		exts:      []string{".js", ".mjs", ".cjs", ".ts"},
+		directive: serviceDirective,
+		handler: regexp.MustCompile(`(?m)^\s*(?:export\s+(?:async\s+)?function\s+(\w+)\s*\(\s*ctx\b|` +
+			`export\s+const\s+(\w+)\s*=\s*(?:async\s+)?(?:function\s*\w*\s*)?\(?\s*ctx\b|` +
+			`(?:module\.)?exports\.(\w+)\s*=\s*(?:async\s+)?(?:function\s*\w*\s*)?\(?\s*ctx\b)`),
+	},
+	"python": {
+		exts:      []string{".py"},
+		directive: "#polycode:service",
+		handler:   regexp.MustCompile(`(?m)^(?:async\s+)?def\s+([A-Za-z]\w*)\s*\(\s*ctx\b`),
+	},
+}
+
+// Service is a service of an app that gets registered on start.
+type Service struct {
+	ID          string `yaml:"id" json:"id"`
+	Description string `yaml:"description" json:"description,omitempty"`
+	// Disabled keeps a service with a service.yaml from being registered.
+	Disabled bool   `yaml:"disabled" json:"disabled,omitempty"`
+	Dir      string `yaml:"-" json:"dir"`
+	Package  string `yaml:"-" json:"package,omitempty"`
+	// Reason says which marker made the folder a service.
+	Reason string `yaml:"-" json:"reason"`
+}
+
+// SkippedDir is a folder under services/ that is not a service.
+type SkippedDir struct {
+	Dir    string `json:"dir"`
+	Reason string `json:"reason"`
+}
+
+// ServiceDiscovery is what discoverServices found in an app.
+type ServiceDiscovery struct {
+	Services []Service    `json:"services"`
+	Skipped  []SkippedDir `json:"skipped"`
+}
+
+// IDs returns the IDs of the services to register.
+func (d *ServiceDiscovery) IDs() []string {
+	var ids []string
+	for _, s := range d.Services {
+		if !s.Disabled {
+			ids = append(ids, s.ID)
+		}
+	}
+	return ids
+}
+
+// sourcePackage is what discovery needs to know about a folder's sources.
+type sourcePackage struct {
+	// name is the Go package name, empty for other runtimes.
+	name string
+	// directive says which directive marks the service and where, and
+	// directiveID is the ID given with it.
+	directive   string
+	directiveID string
+	// handler names the first handler found, e.g. "Create in orders.go".
+	handler string
+}
+
+// discoverServices finds the services of the app in appDir, reading its
+// sources with the rules of runtime. A folder under services/ is a service
+// when it holds a service.yaml or sources with the //polycode:service
+// directive. Apps using neither marker register the folders that export a
+// handler, a function taking the service context first, so shared helper
+// packages are left out. Hidden folders, testdata and vendor are never
+// services.
+func discoverServices(appDir, runtime string) (*ServiceDiscovery, error) {
+	servicesDir := filepath.Join(appDir, "services")
+	entries, err := os.ReadDir(servicesDir)
+	if os.IsNotExist(err) {
+		return &ServiceDiscovery{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read services folder: %w", err)
+	}
+
+	type candidate struct {
+		dir      string
+		manifest *Service
+		pkg      *sourcePackage
+	}
+	var candidates []candidate
+	found := &ServiceDiscovery{}
+	markers := false
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		rel := filepath.ToSlash(filepath.Join("services", name))
+		switch {
+		case strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_"):
+			found.Skipped = append(found.Skipped, SkippedDir{rel, "hidden folder"})
+			continue
+		case name == "testdata" || name == "vendor":
+			found.Skipped = append(found.Skipped, SkippedDir{rel, "ignored by the go tool"})
+			continue
+		}
+
+		dir := filepath.Join(servicesDir, name)
+		c := candidate{dir: rel}
+		if c.manifest, err = loadServiceFile(dir); err != nil {
+			return nil, err
+		}
+		if c.pkg, err = parseServiceSources(dir, runtime); err != nil {
+			return nil, err
+		}
+		if c.manifest != nil || (c.pkg != nil && c.pkg.directive != "") {
+			markers = true
+		}
+		candidates = append(candidates, c)
+	}
+
+	seen := map[string]string{}
+	for _, c := range candidates {
+		base := filepath.Base(c.dir)
+		var svc Service
+		switch {
+		case c.manifest != nil:
+			svc = *c.manifest
+			svc.ID = firstNonEmpty(svc.ID, base)
+			svc.Reason = serviceFile
+		case c.pkg != nil && c.pkg.directive != "":
+			svc = Service{ID: firstNonEmpty(c.pkg.directiveID, base), Reason: c.pkg.directive}
+		case c.pkg == nil:
+			found.Skipped = append(found.Skipped, SkippedDir{c.dir, "no " + runtime + " sources and no " + serviceFile})
+			continue
+		case markers:
+			found.Skipped = append(found.Skipped, SkippedDir{c.dir, "no " + serviceFile + " or " + serviceDirective + ", other services use them"})
+			continue
+		case c.pkg.name == "main":
+			found.Skipped = append(found.Skipped, SkippedDir{c.dir, "package main is a command, not a service"})
+			continue
+		case c.pkg.handler == "":
+			found.Skipped = append(found.Skipped, SkippedDir{c.dir, "no handler taking the service context, a shared package"})
+			continue
+		default:
+			svc = Service{ID: base, Reason: "handler " + c.pkg.handler + " (no service markers in this app)"}
+		}
+		svc.Dir = c.dir
+		if c.pkg != nil {
+			svc.Package = c.pkg.name
+		}
+
+		if other, ok := seen[svc.ID]; ok {
+			return nil, fmt.Errorf("service ID '%s' is used by both %s and %s", svc.ID, other, svc.Dir)
+		}
+		seen[svc.ID] = svc.Dir
+		found.Services = append(found.Services, svc)
+	}
+	return found, nil
+}
+
+// loadServiceFile reads the service.yaml of dir, nil when there is none.
+func loadServiceFile(dir string) (*Service, error) {
+	path := filepath.Join(dir, serviceFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var svc Service
+	if err := yaml.Unmarshal(data, &svc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &svc, nil
+}
+
+// parseServiceSources reads the sources of dir with the rules of runtime,
+// nil when it has none.
+func parseServiceSources(dir, runtime string) (*sourcePackage, error) {
+	if runtime == "go" {
+		return parseGoPackage(dir)
+	}
+	rules, ok := scriptRules[runtime]
+	if !ok {
+		return nil, fmt.Errorf("service discovery does not support the %s runtime", runtime)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	var pkg *sourcePackage
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !slices.Contains(rules.exts, filepath.Ext(name)) || isTestSource(name) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filepath.Join(dir, name), err)
+		}
+		if pkg == nil {
+			pkg = &sourcePackage{}
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			id, ok := strings.CutPrefix(strings.TrimSpace(line), rules.directive)
+			if ok && (id == "" || id[0] == ' ') && pkg.directive == "" {
+				pkg.directive = rules.directive + " in " + name
+				pkg.directiveID = strings.TrimSpace(id)
+			}
+		}
+		if m := rules.handler.FindSubmatch(data); m != nil && pkg.handler == "" {
+			for _, fn := range m[1:] {
+				if len(fn) > 0 {
+					pkg.handler = string(fn) + " in " + name
+					break
+				}
+			}
+		}
+	}
+	return pkg, nil
+}
+
+// isTestSource reports whether a Node or Python file holds tests.
+func isTestSource(name string) bool {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	return strings.HasSuffix(base, ".test") || strings.HasSuffix(base, ".spec") || strings.HasSuffix(base, ".d") ||
+		strings.HasPrefix(base, "test_") || strings.HasSuffix(base, "_test")
+}
+
+// parseGoPackage parses the non-test Go files of dir, nil when it has none.
+func parseGoPackage(dir string) (*sourcePackage, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var pkg *sourcePackage
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		// A file being edited may not parse; what was read before the error
+		// still counts
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if f == nil || f.Name == nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		if pkg == nil {
+			pkg = &sourcePackage{name: f.Name.Name}
+		}
+		if pkg.handler == "" {
+			if fn := goHandler(f); fn != "" {
+				pkg.handler = fn + " in " + filepath.Base(file)
+			}
+		}
+		for _, group := range f.Comments {
+			for _, c := range group.List {
+				if id, ok := strings.CutPrefix(c.Text, serviceDirective); ok && (id == "" || id[0] == ' ') && pkg.directive == "" {
+					pkg.directive = serviceDirective + " in " + filepath.Base(file)
+					pkg.directiveID = strings.TrimSpace(id)
+				}
+			}
+		}
+	}
+	return pkg, nil
+}
+
+// goHandler returns the first exported function of f whose first parameter
+// is a service context, such as polycode.ServiceContext. Functions taking a
+// context.Context are helpers.
+func goHandler(f *ast.File) string {
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || !fn.Name.IsExported() || len(fn.Type.Params.List) == 0 {
+			continue
+		}
+		typ := fn.Type.Params.List[0].Type
+		if star, ok := typ.(*ast.StarExpr); ok {
+			typ = star.X
+		}
+		sel, ok := typ.(*ast.SelectorExpr)
+		if !ok || !strings.HasSuffix(sel.Sel.Name, "Context") {
+			continue
+		}
+		if pkg, ok := sel.X.(*ast.Ident); ok && pkg.Name != "context" {
+			return fn.Name.Name
+		}
+	}
+	return ""
+}
+
+// listServices prints the services of the app in appDir and why each
+// folder under services/ is or is not one.
+func listServices(appDir string, asJSON bool) error {
+	manifest, err := loadManifest(appDir)
+	if err != nil {
+		return err
+	}
+	rt, err := resolveRuntime(appDir, manifest)
+	if err != nil {
+		return err
+	}
+	found, err := discoverServices(appDir, rt.Name)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(found)
+	}
+
+	if len(found.Services) == 0 && len(found.Skipped) == 0 {
+		fmt.Println("No services found in", filepath.Join(appDir, "services"))
+		return nil
+	}
+	for _, s := range found.Services {
+		icon := "✅"
+		if s.Disabled {
+			icon = "⏸️ "
+		}
+		fmt.Printf("%s %-20s %-28s %s\n", icon, s.ID, s.Dir, s.Reason)
+		if s.Description != "" {
+			fmt.Printf("   %-20s %s\n", "", s.Description)
+		}
+	}
+	for _, s := range found.Skipped {
+		fmt.Printf("⏭️  %-20s %-28s %s\n", filepath.Base(s.Dir), s.Dir, s.Reason)
+	}
+	return nil
+}
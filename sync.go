@@ -0,0 +1,391 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type SyncMode string
+
+const (
+	// SyncBind bind-mounts the project root, relying on file events inside
+	// the container.
+	SyncBind SyncMode = "bind"
+	// SyncCopy copies the project into the container at startup and pushes
+	// every change from a host-side watcher.
+	SyncCopy SyncMode = "copy"
+	// SyncMutagenLike works like SyncCopy, but keeps the project in a named
+	// volume and reconciles it by content hash on startup, so only the
+	// differences are sent when an app is run again.
+	SyncMutagenLike SyncMode = "mutagen-like"
+)
+
+const (
+	syncPollInterval = 500 * time.Millisecond
+	// rebuildTrigger is the only file poly-watcher watches in the synced
+	// modes; touching it after a batch triggers exactly one rebuild.
+	rebuildTrigger = ".rebuild-trigger"
+)
+
+func parseSyncMode(s string) (SyncMode, error) {
+	switch m := SyncMode(s); m {
+	case SyncBind, SyncCopy, SyncMutagenLike:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown sync mode '%s' (bind, copy or mutagen-like)", s)
+}
+
+func syncVolumeName(appName string) string {
+	return "polycode-sync-" + appName
+}
+
+// applySync attaches the project to the container for the given mode.
+func applySync(spec *containerSpec, mode SyncMode, projectRoot, appName string) {
+	switch mode {
+	case SyncCopy:
+		spec.Env = append(spec.Env, "SYNC_MODE="+string(mode))
+	case SyncMutagenLike:
+		spec.Binds = append(spec.Binds, syncVolumeName(appName)+":/project")
+		spec.Env = append(spec.Env, "SYNC_MODE="+string(mode))
+	default:
+		spec.Binds = append(spec.Binds, projectRoot+":/project")
+	}
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+// scanTree lists the files under root that are not ignored, keyed by their
+// slash-separated path relative to root.
+// The returned matcher holds every .gitignore that was read.
+func scanTree(root string, extraIgnores []string) (map[string]fileState, *IgnoreMatcher, error) {
+	ignores := newIgnoreMatcher(extraIgnores)
+	files := map[string]fileState{}
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files can disappear while we walk, pick them up next round
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel != "." && ignores.Ignored(rel, true) {
+				return filepath.SkipDir
+			}
+			if rel == "." {
+				rel = ""
+			}
+			ignores.load(root, rel)
+			return nil
+		}
+
+		if ignores.Ignored(rel, false) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if !info.Mode().IsRegular() && info.Mode()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		files[rel] = fileState{size: info.Size(), modTime: info.ModTime(), mode: info.Mode()}
+		return nil
+	})
+	return files, ignores, err
+}
+
+// diffTrees returns the files added or modified in next, and those removed.
+func diffTrees(prev, next map[string]fileState) (changed, removed []string) {
+	for rel, st := range next {
+		if old, ok := prev[rel]; !ok || old != st {
+			changed = append(changed, rel)
+		}
+	}
+	for rel := range prev {
+		if _, ok := next[rel]; !ok {
+			removed = append(removed, rel)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return changed, removed
+}
+
+// writeTar streams the given files from root as a tar archive.
+func writeTar(w io.Writer, root string, rels []string) error {
+	tw := tar.NewWriter(w)
+	for _, rel := range rels {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		info, err := os.Lstat(p)
+		if err != nil {
+			// Removed since it was scanned, the next round deletes it
+			continue
+		}
+
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				continue
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("failed to create tar header for %s: %w", rel, err)
+		}
+		hdr.Name = rel
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			_, err = io.CopyN(tw, f, hdr.Size)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("failed to archive %s: %w", rel, err)
+			}
+		}
+	}
+	return tw.Close()
+}
+
+type Syncer struct {
+	mode         SyncMode
+	container    string
+	projectRoot  string
+	triggerPath  string // relative to /project
+	extraIgnores []string
+	state        map[string]fileState
+}
+
+func newSyncer(mode SyncMode, container, projectRoot, appFolder string, extraIgnores []string) *Syncer {
+	return &Syncer{
+		mode:         mode,
+		container:    container,
+		projectRoot:  projectRoot,
+		triggerPath:  filepath.ToSlash(filepath.Join(appFolder, rebuildTrigger)),
+		extraIgnores: extraIgnores,
+	}
+}
+
+func (s *Syncer) exec(stdin io.Reader, args ...string) error {
+	cmd := containerEngine().Command(append([]string{"exec", "-i", s.container}, args...)...)
+	cmd.Stdin = stdin
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := runCmd(cmd); err != nil {
+		return fmt.Errorf("docker exec %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (s *Syncer) push(rels []string) error {
+	if len(rels) == 0 {
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, s.projectRoot, rels))
+	}()
+	return s.exec(pr, "tar", "-x", "-m", "-C", "/project", "-f", "-")
+}
+
+func (s *Syncer) remove(rels []string) error {
+	if len(rels) == 0 {
+		return nil
+	}
+
+	args := []string{"rm", "-f", "--"}
+	for _, rel := range rels {
+		args = append(args, "/project/"+rel)
+	}
+	return s.exec(nil, args...)
+}
+
+// trigger asks poly-watcher to rebuild once the batch is in place.
+func (s *Syncer) trigger() error {
+	return s.exec(nil, "sh", "-c", fmt.Sprintf("date +%%s%%N > '/project/%s'", s.triggerPath))
+}
+
+// containerHashes lists md5 sums of the files already in the container.
+func (s *Syncer) containerHashes() (map[string]string, error) {
+	cmd := containerEngine().Command("exec", s.container, "sh", "-c",
+		"cd /project && find . -type f -exec md5sum {} +")
+	out, err := cmdOutput(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list synced files: %w", err)
+	}
+
+	hashes := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			continue
+		}
+		hashes[strings.TrimPrefix(name, "./")] = sum
+	}
+	return hashes, nil
+}
+
+func fileMD5(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// initial brings the container in line with the host tree.
+func (s *Syncer) initial() error {
+	files, ignores, err := scanTree(s.projectRoot, s.extraIgnores)
+	if err != nil {
+		return fmt.Errorf("failed to scan project: %w", err)
+	}
+
+	var changed []string
+	var removed []string
+
+	if s.mode == SyncMutagenLike {
+		remote, err := s.containerHashes()
+		if err != nil {
+			return err
+		}
+		for rel := range remote {
+			// Leave what the container produced in ignored paths alone
+			if _, ok := files[rel]; !ok && rel != s.triggerPath && !ignores.IgnoredPath(rel) {
+				removed = append(removed, rel)
+			}
+		}
+		for rel, st := range files {
+			sum := ""
+			if st.mode.IsRegular() {
+				sum, _ = fileMD5(filepath.Join(s.projectRoot, filepath.FromSlash(rel)))
+			}
+			if sum == "" || remote[rel] != sum {
+				changed = append(changed, rel)
+			}
+		}
+		sort.Strings(changed)
+	} else {
+		for rel := range files {
+			changed = append(changed, rel)
+		}
+		sort.Strings(changed)
+	}
+
+	if err := s.remove(removed); err != nil {
+		return err
+	}
+	if err := s.push(changed); err != nil {
+		return err
+	}
+	fmt.Printf("🔄 Synced %d files (%d removed).\n", len(changed), len(removed))
+
+	s.state = files
+	return s.exec(nil, "touch", "/tmp/sync-ready")
+}
+
+// watch polls the host tree and pushes every change until done is closed.
+func (s *Syncer) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(syncPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		files, _, err := scanTree(s.projectRoot, s.extraIgnores)
+		if err != nil {
+			fmt.Println("⚠️  sync:", err)
+			continue
+		}
+
+		changed, removed := diffTrees(s.state, files)
+		if len(changed) == 0 && len(removed) == 0 {
+			continue
+		}
+
+		if err := s.remove(removed); err != nil {
+			fmt.Println("⚠️  sync:", err)
+			continue
+		}
+		if err := s.push(changed); err != nil {
+			fmt.Println("⚠️  sync:", err)
+			continue
+		}
+		s.state = files
+
+		fmt.Printf("🔄 Synced %d changed, %d removed.\n", len(changed), len(removed))
+		if err := s.trigger(); err != nil {
+			fmt.Println("⚠️  sync:", err)
+		}
+	}
+}
+
+// waitForContainer blocks until the named container is running.
+func waitForContainer(name string, timeout time.Duration) error {
+	if api := engineAPI(); api != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := api.WaitRunning(ctx, name); err != nil {
+			return fmt.Errorf("container %s did not start within %s: %w", name, timeout, err)
+		}
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		out, err := cmdOutput(containerEngine().Command("inspect", "-f", "{{.State.Running}}", name))
+		if err == nil && strings.TrimSpace(string(out)) == "true" {
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return fmt.Errorf("container %s did not start within %s", name, timeout)
+}
+
+// stopContainer stops the named container, giving it a second to exit.
+func stopContainer(name string) error {
+	if api := engineAPI(); api != nil {
+		return api.ContainerStop(context.Background(), name, time.Second)
+	}
+	return runCmd(containerEngine().Command("stop", "-t", "1", name))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Toolchain pins the tools baked into the dev image. Empty fields fall back
+// to the next source in line: project manifest, CLI config, then defaults.
+// BaseImage may be pinned to a digest ("repo@sha256:...").
+type Toolchain struct {
+	BaseImage   string `yaml:"baseImage" json:"baseImage,omitempty"`
+	Dlv         string `yaml:"dlv" json:"dlv,omitempty"`
+	PolyWatcher string `yaml:"polyWatcher" json:"polyWatcher,omitempty"`
+
+	// BuilderImage is the Go image the dev tools are compiled in.
+	BuilderImage string `yaml:"-" json:"-"`
+}
+
+// builderRepository is the Go builder in the profile's platform registry.
+const builderRepository = "polycode/next-builder:latest"
+
+func defaultToolchain(registry RegistryConfig) Toolchain {
+	builder := registry.platformRegistry() + "/" + builderRepository
+	return Toolchain{
+		BaseImage:    builder,
+		Dlv:          "v1.23.1",
+		PolyWatcher:  "v0.3.0",
+		BuilderImage: builder,
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// resolveToolchain applies the manifest and CLI config on top of the
+// defaults for rt. The base image in the CLI config pins the Go builder, so it
+// only replaces the base image of Go apps.
+func resolveToolchain(manifest *Manifest, rt Runtime, registry RegistryConfig) (Toolchain, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return Toolchain{}, err
+	}
+
+	def := defaultToolchain(registry)
+	t := Toolchain{
+		Dlv:          firstNonEmpty(manifest.Toolchain.Dlv, cfg.Toolchain.Dlv, def.Dlv),
+		PolyWatcher:  firstNonEmpty(manifest.Toolchain.PolyWatcher, cfg.Toolchain.PolyWatcher, def.PolyWatcher),
+		BuilderImage: firstNonEmpty(cfg.Toolchain.BaseImage, def.BuilderImage),
+	}
+	if rt.Name == "go" {
+		t.BaseImage = firstNonEmpty(manifest.Toolchain.BaseImage, cfg.Toolchain.BaseImage, rt.BaseImage, def.BaseImage)
+		t.BuilderImage = t.BaseImage
+	} else {
+		t.BaseImage = firstNonEmpty(manifest.Toolchain.BaseImage, rt.BaseImage)
+	}
+	return t, nil
+}
+
+func (t Toolchain) buildArgs() []string {
+	return []string{
+		"--build-arg", "BUILDER_IMAGE=" + t.BuilderImage,
+		"--build-arg", "BASE_IMAGE=" + t.BaseImage,
+		"--build-arg", "DLV_VERSION=" + t.Dlv,
+		"--build-arg", "POLY_WATCHER_VERSION=" + t.PolyWatcher,
+	}
+}
+
+// baseImageDigest returns the digest the base image resolves to, using the
+// pinned digest when the reference already carries one.
+func (t Toolchain) baseImageDigest() (string, error) {
+	if i := strings.Index(t.BaseImage, "@"); i >= 0 {
+		return t.BaseImage[i+1:], nil
+	}
+	return remoteImageDigest(t.BaseImage)
+}
+
+// Image labels recording which toolchain an image was built with
+const (
+	labelApp         = "polycode.app"
+	labelBaseImage   = "polycode.toolchain.base-image"
+	labelBaseDigest  = "polycode.toolchain.base-digest"
+	labelDlv         = "polycode.toolchain.dlv"
+	labelPolyWatcher = "polycode.toolchain.poly-watcher"
+)
+
+func toolchainLabels(appName string, t Toolchain, baseDigest string) []string {
+	return []string{
+		"--label", labelApp + "=" + appName,
+		"--label", labelBaseImage + "=" + t.BaseImage,
+		"--label", labelBaseDigest + "=" + baseDigest,
+		"--label", labelDlv + "=" + t.Dlv,
+		"--label", labelPolyWatcher + "=" + t.PolyWatcher,
+	}
+}
+
+func imageLabel(imageTag, label string) string {
+	if api := engineAPI(); api != nil {
+		image, err := api.ImageInspect(context.Background(), imageTag)
+		if err != nil {
+			return ""
+		}
+		return image.Config.Labels[label]
+	}
+
+	out, err := cmdOutput(containerEngine().Command("image", "inspect",
+		"--format", fmt.Sprintf("{{index .Config.Labels %q}}", label), imageTag))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// installedToolVersions asks the image itself which module versions the
+// installed binaries were built from, since "latest" says nothing.
+func installedToolVersions(imageTag string) (string, error) {
+	script := `go version; for b in dlv poly-watcher; do p=$(command -v $b) && go version -m "$p" | awk '$1 == "mod" { print "'"$b"'", $3 }'; done`
+	out, err := cmdOutput(containerEngine().Command("run", "--rm", "--entrypoint", "sh", imageTag, "-c", script))
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect tools in %s: %w", imageTag, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func toolchainReport() error {
+	out, err := cmdOutput(containerEngine().Command("image", "ls",
+		"--filter", "label="+labelApp,
+		"--format", "{{.Repository}}:{{.Tag}}"))
+	if err != nil {
+		return fmt.Errorf("failed to list images: %w", err)
+	}
+
+	images := strings.Fields(string(out))
+	if len(images) == 0 {
+		fmt.Println("No polycode images built yet.")
+		return nil
+	}
+
+	for _, image := range images {
+		fmt.Println("📦", image)
+		fmt.Println("   base image  :", imageLabel(image, labelBaseImage))
+		fmt.Println("   base digest :", imageLabel(image, labelBaseDigest))
+		fmt.Println("   dlv         :", imageLabel(image, labelDlv))
+		fmt.Println("   poly-watcher:", imageLabel(image, labelPolyWatcher))
+
+		versions, err := installedToolVersions(image)
+		if err != nil {
+			fmt.Println("   ⚠️ ", err)
+			continue
+		}
+		fmt.Println("   installed   :")
+		for _, line := range strings.Split(versions, "\n") {
+			fmt.Println("     " + line)
+		}
+	}
+	return nil
+}